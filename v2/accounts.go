@@ -0,0 +1,310 @@
+package goth_fiber
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/markbates/goth"
+)
+
+const (
+	// accountSessionKey holds the ID of the account logged in to.
+	accountSessionKey = "_goth_account"
+
+	// linkSessionKey holds the account BeginLinkHandler links the next
+	// identity to, and when it started.
+	linkSessionKey = "_goth_link"
+
+	// linkLifetime is how long a link started by BeginLinkHandler can be
+	// completed for.
+	linkLifetime = 10 * time.Minute
+)
+
+// AccountResult is the outcome of a login through AccountCallbackHandler.
+type AccountResult struct {
+	User    goth.User
+	Account *Account
+
+	// SignUp is set when the account was created by this login.
+	SignUp bool
+
+	// Linked is set when the identity was linked to an existing account by
+	// this login.
+	Linked bool
+
+	// FirstLogin is set when the identity logged in for the first time, on
+	// sign-up or when it was linked.
+	FirstLogin bool
+}
+
+// Options that affect how AccountCallbackHandler works.
+type AccountOptions struct {
+	// Store keeps the accounts. It is required.
+	Store UserStore
+
+	// LinkVerifiedEmail links new identities to the account with the same
+	// email, when their provider reports the email as verified and so did
+	// the provider the account was created with, see Account.EmailVerified.
+	// Otherwise they get an account of their own.
+	//
+	// Defaults to false.
+	LinkVerifiedEmail bool
+
+	// OnboardingURL is where the default Success sends new accounts, which
+	// can then go on to GetReturnTo. Usually the URL of the first Gate of
+	// RequireOnboarding.
+	OnboardingURL string
+
+	// Success responds once the user is logged in to its account.
+	//
+	// Defaults to redirecting sign-ups to OnboardingURL when it is set, and
+	// others to GetReturnTo, or "/".
+	Success func(ctx fiber.Ctx, result *AccountResult) error
+
+	// ErrorHandler is called when the login fails.
+	//
+	// Defaults to responding with ErrorStatus and the error message.
+	ErrorHandler func(ctx fiber.Ctx, err error) error
+}
+
+/*
+AccountCallbackHandler returns a callback handler completing the
+authentication and logging the user in to its account in Store: the account
+the identity is linked to (sign-in), or a new one (sign-up).
+
+When the login was started by BeginLinkHandler, the identity is linked to the
+account the session is logged in to instead, which is how users add
+providers to their account. Identities linked to another account are then
+rejected with ErrIdentityInUse. Other logins sign in or sign up, whatever
+account the session was logged in to. When the user cannot be logged in to
+an account, the session is logged out. When the authentication itself fails,
+e.g. with a provider error or a state mismatch, the session is left as
+CompleteUserAuth leaves it: users rejected by an admission policy are logged
+out, while the session user stays logged in otherwise.
+
+The session is kept, see CompleteUserAuthOptions.ShouldLogout, and the ID of
+the account is stored in it, see GetAccountID.
+*/
+func AccountCallbackHandler(options ...AccountOptions) fiber.Handler {
+	var opts AccountOptions
+	if len(options) > 0 {
+		opts = options[0]
+	}
+
+	if opts.Store == nil {
+		panic("goth_fiber: AccountOptions.Store is required")
+	}
+
+	if opts.Success == nil {
+		opts.Success = func(ctx fiber.Ctx, result *AccountResult) error {
+			if result.SignUp && opts.OnboardingURL != "" {
+				// keep the return-to for the onboarding to go on to
+				if err := StoreInSession(returnToSessionKey, GetReturnTo(ctx), ctx); err != nil {
+					return err
+				}
+				return ctx.Redirect().To(opts.OnboardingURL)
+			}
+			if returnTo := GetReturnTo(ctx); returnTo != "" {
+				return ctx.Redirect().To(returnTo)
+			}
+			return ctx.Redirect().To("/")
+		}
+	}
+
+	if opts.ErrorHandler == nil {
+		opts.ErrorHandler = func(ctx fiber.Ctx, err error) error {
+			return ctx.Status(ErrorStatus(err)).SendString(err.Error())
+		}
+	}
+
+	return func(ctx fiber.Ctx) error {
+		// read before CompleteUserAuth, which may log a rejected user out
+		linkTo, err := takeLinkIntent(ctx)
+		if err != nil {
+			return opts.ErrorHandler(ctx, err)
+		}
+
+		user, err := CompleteUserAuth(ctx, CompleteUserAuthOptions{ShouldLogout: false})
+		if err != nil {
+			return opts.ErrorHandler(ctx, err)
+		}
+
+		result, err := resolveAccount(ctx, opts, linkTo, user)
+		if err == nil {
+			err = StoreInSession(accountSessionKey, result.Account.ID, ctx)
+		}
+		if err != nil {
+			_ = Logout(ctx)
+			return opts.ErrorHandler(ctx, err)
+		}
+
+		return opts.Success(ctx, result)
+	}
+}
+
+// resolveAccount finds, links or creates the account of user, linkTo being
+// the ID of the account BeginLinkHandler links the identity to, if any.
+func resolveAccount(ctx fiber.Ctx, opts AccountOptions, linkTo string, user goth.User) (*AccountResult, error) {
+	c := ctx.Context()
+	identity := IdentityOf(user)
+	result := &AccountResult{User: user}
+
+	account, err := opts.Store.FindByIdentity(c, identity)
+	switch {
+	case err == nil:
+		if linkTo != "" && account.ID != linkTo {
+			return nil, ErrIdentityInUse
+		}
+		result.Account = account
+		return result, nil
+	case !errors.Is(err, ErrAccountNotFound):
+		return nil, err
+	}
+
+	// both emails must be verified, or anyone could sign up with the
+	// address of someone else and get their identities linked
+	if linkTo == "" && opts.LinkVerifiedEmail && user.Email != "" && emailVerified(user) {
+		account, err := opts.Store.FindByEmail(c, user.Email)
+		if err == nil && account.EmailVerified {
+			linkTo = account.ID
+		} else if err != nil && !errors.Is(err, ErrAccountNotFound) {
+			return nil, err
+		}
+	}
+
+	if linkTo != "" {
+		if err := opts.Store.LinkIdentity(c, linkTo, identity); err != nil {
+			return nil, err
+		}
+
+		account, err := opts.Store.FindByIdentity(c, identity)
+		if err != nil {
+			return nil, err
+		}
+
+		result.Account, result.Linked, result.FirstLogin = account, true, true
+		return result, nil
+	}
+
+	account = &Account{
+		Email:         user.Email,
+		EmailVerified: user.Email != "" && emailVerified(user),
+		Name:          user.Name,
+		Identities:    []Identity{identity},
+	}
+	if err := opts.Store.Create(c, account); err != nil {
+		return nil, err
+	}
+
+	result.Account, result.SignUp, result.FirstLogin = account, true, true
+	return result, nil
+}
+
+// GetAccountID returns the ID of the account the session is logged in to,
+// see AccountCallbackHandler.
+func GetAccountID(ctx fiber.Ctx) (string, error) {
+	id, err := GetFromSession(accountSessionKey, ctx)
+	if err == nil && id == "" {
+		err = ErrAccountNotFound
+	}
+	return id, err
+}
+
+// Options that affect how BeginLinkHandler works.
+type LinkOptions struct {
+	// MaxAge is how recently the user must have authenticated to link an
+	// identity, see GetAuthTime.
+	//
+	// Defaults to 5 minutes.
+	MaxAge time.Duration
+
+	// ErrorHandler is called when the link cannot be started, with
+	// ErrNotAuthenticated without an account, ErrStaleAuth when the
+	// authentication is too old, or the error of GetAuthURL.
+	//
+	// Defaults to responding with ErrorStatus and the error message.
+	ErrorHandler func(ctx fiber.Ctx, err error) error
+}
+
+/*
+BeginLinkHandler returns a handler starting the authentication of an identity
+to link to the account the session is logged in to, like BeginAuthHandler.
+AccountCallbackHandler completes the link. The user must have authenticated
+recently, put RequireFreshAuth in front of it to send users whose login is
+too old to their provider first.
+*/
+func BeginLinkHandler(options ...LinkOptions) fiber.Handler {
+	var opts LinkOptions
+	if len(options) > 0 {
+		opts = options[0]
+	}
+
+	if opts.MaxAge <= 0 {
+		opts.MaxAge = 5 * time.Minute
+	}
+
+	if opts.ErrorHandler == nil {
+		opts.ErrorHandler = func(ctx fiber.Ctx, err error) error {
+			return ctx.Status(ErrorStatus(err)).SendString(err.Error())
+		}
+	}
+
+	return func(ctx fiber.Ctx) error {
+		accountID, err := GetAccountID(ctx)
+		if err != nil {
+			return opts.ErrorHandler(ctx, fmt.Errorf("%w: %w", ErrNotAuthenticated, err))
+		}
+
+		if authTime, err := GetAuthTime(ctx); err != nil || time.Since(authTime) > opts.MaxAge {
+			return opts.ErrorHandler(ctx, ErrStaleAuth)
+		}
+
+		authURL, err := GetAuthURL(ctx)
+		if err != nil {
+			return opts.ErrorHandler(ctx, err)
+		}
+
+		intent := url.Values{
+			"account": {accountID},
+			"started": {strconv.FormatInt(time.Now().Unix(), 10)},
+		}
+		if err := StoreInSession(linkSessionKey, intent.Encode(), ctx); err != nil {
+			return err
+		}
+
+		return ctx.Redirect().Status(fiber.StatusTemporaryRedirect).To(authURL)
+	}
+}
+
+// takeLinkIntent returns the account BeginLinkHandler started a link to, if
+// the session is still logged in to it, and clears the link.
+func takeLinkIntent(ctx fiber.Ctx) (string, error) {
+	value, err := GetFromSession(linkSessionKey, ctx)
+	if err != nil || value == "" {
+		return "", nil
+	}
+
+	if err := StoreInSession(linkSessionKey, "", ctx); err != nil {
+		return "", err
+	}
+
+	intent, err := url.ParseQuery(value)
+	if err != nil {
+		return "", nil
+	}
+
+	seconds, err := strconv.ParseInt(intent.Get("started"), 10, 64)
+	if err != nil || time.Since(time.Unix(seconds, 0)) > linkLifetime {
+		return "", nil
+	}
+
+	if current, err := GetAccountID(ctx); err != nil || current != intent.Get("account") {
+		return "", nil
+	}
+
+	return intent.Get("account"), nil
+}
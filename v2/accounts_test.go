@@ -0,0 +1,187 @@
+package goth_fiber
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/markbates/goth"
+	"github.com/markbates/goth/providers/faux"
+)
+
+type accountLogin struct {
+	Status  int
+	Account string
+	SignUp  bool
+	Linked  bool
+	First   bool
+	Cookies []*http.Cookie
+}
+
+// loginToAccount runs the login flow of provider started at begin, carrying cookies.
+func loginToAccount(t *testing.T, app *fiber.App, begin, provider string, cookies []*http.Cookie) accountLogin {
+	t.Helper()
+
+	req := httptest.NewRequest("GET", begin+provider+"?state=test-state", nil)
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req = httptest.NewRequest("GET", "/callback/"+provider+"?code=test-code&state=test-state", nil)
+	for _, cookie := range resp.Cookies() {
+		req.AddCookie(cookie)
+	}
+	if resp.StatusCode != fiber.StatusTemporaryRedirect {
+		return accountLogin{Status: resp.StatusCode}
+	}
+	resp, err = app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	login := accountLogin{Status: resp.StatusCode, Cookies: resp.Cookies()}
+	if resp.StatusCode == fiber.StatusOK {
+		if err := json.NewDecoder(resp.Body).Decode(&login); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return login
+}
+
+func Test_AccountCallbackHandler(t *testing.T) {
+	goth.ClearProviders()
+	goth.UseProviders(&faux.Provider{})
+	RegisterProviderAlias("other", &faux.Provider{})
+	RegisterProviderAlias("third", &faux.Provider{})
+	RegisterProviderAlias("fourth", &faux.Provider{})
+	defer RemoveProviderAlias("other")
+	defer RemoveProviderAlias("third")
+	defer RemoveProviderAlias("fourth")
+
+	store := NewMemoryUserStore()
+	taken := &Account{Identities: []Identity{{Provider: "third", UserID: "id"}}}
+	if err := store.Create(context.Background(), taken); err != nil {
+		t.Fatal(err)
+	}
+
+	app := fiber.New()
+	app.Get("/auth/:provider", BeginAuthHandler)
+	app.Get("/link/:provider", BeginLinkHandler())
+	app.Get("/age", func(c fiber.Ctx) error {
+		return StoreInSession(authTimeSessionKey, strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10), c)
+	})
+	app.Get("/callback/:provider", AccountCallbackHandler(AccountOptions{
+		Store: store,
+		Success: func(c fiber.Ctx, result *AccountResult) error {
+			id, err := GetAccountID(c)
+			if err != nil || id != result.Account.ID {
+				t.Errorf("expected the account to be stored in the session, got %q %v", id, err)
+			}
+			return c.JSON(fiber.Map{"account": result.Account.ID, "signup": result.SignUp, "linked": result.Linked, "first": result.FirstLogin})
+		},
+	}))
+
+	signUp := loginToAccount(t, app, "/auth/", "faux", nil)
+	if signUp.Status != fiber.StatusOK || !signUp.SignUp || signUp.Linked || !signUp.First {
+		t.Fatalf("expected a sign-up, got %+v", signUp)
+	}
+
+	signIn := loginToAccount(t, app, "/auth/", "faux", nil)
+	if signIn.Account != signUp.Account || signIn.SignUp || signIn.Linked || signIn.First {
+		t.Errorf("expected a sign-in to the same account, got %+v", signIn)
+	}
+
+	if anonymous := loginToAccount(t, app, "/link/", "other", nil); anonymous.Status != fiber.StatusUnauthorized {
+		t.Errorf("expected links without an account to be rejected, got %+v", anonymous)
+	}
+
+	linked := loginToAccount(t, app, "/link/", "other", signUp.Cookies)
+	if linked.Account != signUp.Account || !linked.Linked || !linked.First {
+		t.Errorf("expected the identity to be linked to the logged in account, got %+v", linked)
+	}
+
+	linkedSignIn := loginToAccount(t, app, "/auth/", "other", nil)
+	if linkedSignIn.Account != signUp.Account || linkedSignIn.SignUp {
+		t.Errorf("expected the linked identity to sign in to the account, got %+v", linkedSignIn)
+	}
+
+	conflict := loginToAccount(t, app, "/link/", "third", signUp.Cookies)
+	if conflict.Status != fiber.StatusConflict {
+		t.Errorf("expected identities of other accounts not to be linked, got %+v", conflict)
+	}
+	if account, _ := store.FindByIdentity(context.Background(), Identity{Provider: "third", UserID: "id"}); account.ID != taken.ID {
+		t.Errorf("expected the identity to stay linked to its account, got %+v", account)
+	}
+
+	// a login on a browser logged in to another account does not link
+	shared := loginToAccount(t, app, "/auth/", "faux", nil)
+	other := loginToAccount(t, app, "/auth/", "fourth", shared.Cookies)
+	if other.Account == signUp.Account || !other.SignUp || other.Linked {
+		t.Errorf("expected a login without a link to sign up, got %+v", other)
+	}
+
+	stale := loginToAccount(t, app, "/auth/", "faux", nil)
+	req := httptest.NewRequest("GET", "/age", nil)
+	for _, cookie := range stale.Cookies {
+		req.AddCookie(cookie)
+	}
+	if _, err := app.Test(req); err != nil {
+		t.Fatal(err)
+	}
+	if link := loginToAccount(t, app, "/link/", "fourth", stale.Cookies); link.Status != fiber.StatusUnauthorized {
+		t.Errorf("expected links with a stale authentication to be rejected, got %+v", link)
+	}
+}
+
+func Test_AccountCallbackHandler_LinkVerifiedEmail(t *testing.T) {
+	t.Parallel()
+
+	store := NewMemoryUserStore()
+	jane := &Account{Email: "jane@example.com", EmailVerified: true, Identities: []Identity{{Provider: "google", UserID: "1"}}}
+	if err := store.Create(context.Background(), jane); err != nil {
+		t.Fatal(err)
+	}
+
+	verified := goth.User{Provider: "github", UserID: "2", Email: "Jane@example.com", RawData: map[string]interface{}{"email_verified": true}}
+	unverified := goth.User{Provider: "gitlab", UserID: "3", Email: "jane@example.com", RawData: map[string]interface{}{}}
+
+	// signed up through a provider not verifying the address of the victim
+	squatted := goth.User{Provider: "gitlab", UserID: "4", Email: "john@example.com", RawData: map[string]interface{}{}}
+	john := goth.User{Provider: "google", UserID: "5", Email: "john@example.com", RawData: map[string]interface{}{"email_verified": true}}
+
+	app := fiber.New()
+	app.Get("/", func(c fiber.Ctx) error {
+		result, err := resolveAccount(c, AccountOptions{Store: store, LinkVerifiedEmail: true}, "", verified)
+		if err != nil || result.Account.ID != jane.ID || !result.Linked {
+			t.Errorf("expected the verified email to link the identity, got %+v %v", result, err)
+		}
+
+		result, err = resolveAccount(c, AccountOptions{Store: store, LinkVerifiedEmail: true}, "", unverified)
+		if err != nil || result.Account.ID == jane.ID || !result.SignUp || result.Account.EmailVerified {
+			t.Errorf("expected the unverified email to get its own account, got %+v %v", result, err)
+		}
+
+		squatter, err := resolveAccount(c, AccountOptions{Store: store, LinkVerifiedEmail: true}, "", squatted)
+		if err != nil {
+			t.Fatal(err)
+		}
+		result, err = resolveAccount(c, AccountOptions{Store: store, LinkVerifiedEmail: true}, "", john)
+		if err != nil || result.Account.ID == squatter.Account.ID || !result.SignUp || !result.Account.EmailVerified {
+			t.Errorf("expected the verified email not to link to an unverified account, got %+v %v", result, err)
+		}
+		return nil
+	})
+
+	if _, err := app.Test(httptest.NewRequest("GET", "/", nil)); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,193 @@
+package goth_fiber
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/markbates/goth"
+)
+
+// ErrAccessDenied is wrapped by the errors CompleteUserAuth returns when an
+// admission policy rejects the user.
+var ErrAccessDenied = errors.New("goth_fiber: access denied")
+
+// AccessDeniedError is returned by CompleteUserAuth when an admission policy
+// rejects the user. It wraps ErrAccessDenied.
+type AccessDeniedError struct {
+	Provider string
+	UserID   string
+	Email    string
+	Reason   string
+}
+
+func (e *AccessDeniedError) Error() string {
+	return ErrAccessDenied.Error() + ": " + e.Reason
+}
+
+func (e *AccessDeniedError) Unwrap() error {
+	return ErrAccessDenied
+}
+
+// AdmissionPolicy decides whether a user who completed the authentication
+// is let in. It returns nil to admit the user, an *AccessDeniedError to
+// reject it, or any other error when it cannot decide.
+type AdmissionPolicy func(ctx fiber.Ctx, user goth.User) error
+
+// AdmissionPolicies are evaluated in order by CompleteUserAuth for all
+// providers, before the AdmissionPolicies of the ProviderOptions.
+var AdmissionPolicies []AdmissionPolicy
+
+// AdmissionEvent describes the outcome of the admission of a user.
+type AdmissionEvent struct {
+	Time     time.Time
+	Provider string
+	UserID   string
+	Email    string
+	IP       string
+	Admitted bool
+
+	// Reason is why the user was rejected.
+	Reason string
+}
+
+// AdmissionAuditor is called with the outcome of every admission, e.g. to
+// write an audit log.
+var AdmissionAuditor func(ctx fiber.Ctx, event AdmissionEvent)
+
+// Deny returns an *AccessDeniedError for reason, for custom admission policies.
+func Deny(reason string) error {
+	return &AccessDeniedError{Reason: reason}
+}
+
+// admit evaluates the admission policies for user.
+func admit(ctx fiber.Ctx, providerName string, user goth.User) error {
+	policies := append(append([]AdmissionPolicy{}, AdmissionPolicies...), requestProviderOptions(ctx, providerName).AdmissionPolicies...)
+
+	var err error
+	for _, policy := range policies {
+		if err = policy(ctx, user); err != nil {
+			break
+		}
+	}
+
+	var denied *AccessDeniedError
+	if errors.As(err, &denied) {
+		denied.Provider, denied.UserID, denied.Email = providerName, user.UserID, user.Email
+	}
+
+	if AdmissionAuditor != nil {
+		event := AdmissionEvent{
+			Time:     time.Now(),
+			Provider: providerName,
+			UserID:   user.UserID,
+			Email:    user.Email,
+			IP:       ctx.IP(),
+			Admitted: err == nil,
+		}
+		if err != nil {
+			event.Reason = err.Error()
+			if denied != nil {
+				event.Reason = denied.Reason
+			}
+		}
+		AdmissionAuditor(ctx, event)
+	}
+
+	return err
+}
+
+// AllowEmailDomains admits users whose email belongs to one of domains. The
+// email must be reported as verified by the provider, as some providers let
+// users set any email.
+func AllowEmailDomains(domains ...string) AdmissionPolicy {
+	return func(ctx fiber.Ctx, user goth.User) error {
+		if !emailVerified(user) {
+			return Deny("email is not verified")
+		}
+
+		at := strings.LastIndexByte(user.Email, '@')
+		if at >= 0 {
+			domain := strings.ToLower(user.Email[at+1:])
+			for _, allowed := range domains {
+				if domain == strings.ToLower(allowed) {
+					return nil
+				}
+			}
+		}
+
+		return Deny(fmt.Sprintf("email domain of %q is not allowed", user.Email))
+	}
+}
+
+/*
+RequireVerifiedEmail admits users whose provider reports their email as
+verified, in the "email_verified" or "verified_email" claim of RawData. It
+only applies to the given providers, or to all of them when none are given.
+*/
+func RequireVerifiedEmail(providers ...string) AdmissionPolicy {
+	return func(ctx fiber.Ctx, user goth.User) error {
+		if len(providers) > 0 && !containsString(providers, user.Provider) {
+			return nil
+		}
+
+		if emailVerified(user) {
+			return nil
+		}
+		return Deny("email is not verified")
+	}
+}
+
+// emailVerified reports whether the provider of user reports its email as verified.
+func emailVerified(user goth.User) bool {
+	for _, claim := range []string{"email_verified", "verified_email"} {
+		switch v := user.RawData[claim].(type) {
+		case bool:
+			if v {
+				return true
+			}
+		case string:
+			if v == "true" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// AllowUsers admits only the users whose email or provider:user_id, e.g.
+// "github:1234", is in users. Emails only match when the provider reports
+// them as verified, as some providers let users set any email.
+func AllowUsers(users ...string) AdmissionPolicy {
+	return func(ctx fiber.Ctx, user goth.User) error {
+		if matchesUser(user, users, true) {
+			return nil
+		}
+		return Deny("user is not allowed")
+	}
+}
+
+// DenyUsers rejects the users whose email or provider:user_id is in users.
+// Emails match whether they are verified or not.
+func DenyUsers(users ...string) AdmissionPolicy {
+	return func(ctx fiber.Ctx, user goth.User) error {
+		if matchesUser(user, users, false) {
+			return Deny("user is denied")
+		}
+		return nil
+	}
+}
+
+// matchesUser reports whether user is in users, by email, only when verified
+// if verifiedEmail is set, or by provider:user_id.
+func matchesUser(user goth.User, users []string, verifiedEmail bool) bool {
+	matchEmail := user.Email != "" && (!verifiedEmail || emailVerified(user))
+	for _, u := range users {
+		if (matchEmail && strings.EqualFold(u, user.Email)) || u == user.Provider+":"+user.UserID {
+			return true
+		}
+	}
+	return false
+}
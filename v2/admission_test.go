@@ -0,0 +1,108 @@
+package goth_fiber
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/markbates/goth"
+	"github.com/markbates/goth/providers/faux"
+)
+
+func Test_AdmissionPolicies(t *testing.T) {
+	t.Parallel()
+
+	jane := goth.User{Provider: "google", UserID: "1", Email: "jane@example.com", RawData: map[string]interface{}{"email_verified": true}}
+	john := goth.User{Provider: "github", UserID: "2", Email: "john@gmail.com", RawData: map[string]interface{}{}}
+
+	tests := []struct {
+		name   string
+		policy AdmissionPolicy
+		user   goth.User
+		admit  bool
+	}{
+		{"allowed domain", AllowEmailDomains("Example.com"), jane, true},
+		{"other domain", AllowEmailDomains("example.com"), john, false},
+		{"allowed domain, unverified", AllowEmailDomains("gmail.com"), john, false},
+		{"verified email", RequireVerifiedEmail(), jane, true},
+		{"unverified email", RequireVerifiedEmail(), john, false},
+		{"verification of other providers", RequireVerifiedEmail("google"), john, true},
+		{"allowed user", AllowUsers("github:2"), john, true},
+		{"not allowed user", AllowUsers("github:2"), jane, false},
+		{"allowed verified email", AllowUsers("jane@example.com"), jane, true},
+		{"allowed unverified email", AllowUsers("john@gmail.com"), john, false},
+		{"denied user", DenyUsers("JANE@example.com"), jane, false},
+		{"not denied user", DenyUsers("jane@example.com"), john, true},
+		{"denied unverified email", DenyUsers("john@gmail.com"), john, false},
+	}
+
+	app := fiber.New()
+	app.Get("/", func(c fiber.Ctx) error {
+		for _, tt := range tests {
+			err := tt.policy(c, tt.user)
+			if tt.admit && err != nil {
+				t.Errorf("%s: expected the user to be admitted, got %v", tt.name, err)
+			}
+			if !tt.admit && !errors.Is(err, ErrAccessDenied) {
+				t.Errorf("%s: expected ErrAccessDenied, got %v", tt.name, err)
+			}
+		}
+		return nil
+	})
+
+	if _, err := app.Test(httptest.NewRequest("GET", "/", nil)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_CompleteUserAuth_AdmissionDenied(t *testing.T) {
+	goth.ClearProviders()
+	goth.UseProviders(&faux.Provider{})
+
+	var events []AdmissionEvent
+	AdmissionPolicies = []AdmissionPolicy{DenyUsers("faux:id")}
+	AdmissionAuditor = func(ctx fiber.Ctx, event AdmissionEvent) {
+		events = append(events, event)
+	}
+	defer func() {
+		AdmissionPolicies = nil
+		AdmissionAuditor = nil
+	}()
+
+	app := fiber.New()
+	app.Get("/auth/:provider", BeginAuthHandler)
+	app.Get("/callback/:provider", func(c fiber.Ctx) error {
+		_, err := CompleteUserAuth(c, CompleteUserAuthOptions{ShouldLogout: false})
+
+		var denied *AccessDeniedError
+		if !errors.As(err, &denied) || denied.Provider != "faux" || denied.UserID != "id" {
+			t.Errorf("expected an AccessDeniedError for the user, got %v", err)
+		}
+		if _, err := GetUserFromSession(c); err == nil {
+			t.Error("expected the rejected user not to be kept in the session")
+		}
+		return c.Status(ErrorStatus(err)).SendString(ErrorCode(err))
+	})
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/auth/faux?state=test-state", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest("GET", "/callback/faux?code=test-code&state=test-state", nil)
+	for _, cookie := range resp.Cookies() {
+		req.AddCookie(cookie)
+	}
+	resp, err = app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != fiber.StatusForbidden {
+		t.Errorf("expected 403, got %d", resp.StatusCode)
+	}
+
+	if len(events) != 1 || events[0].Admitted || events[0].Reason != "user is denied" || events[0].UserID != "id" {
+		t.Errorf("expected an audit event for the rejection, got %+v", events)
+	}
+}
@@ -0,0 +1,178 @@
+package goth_fiber
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/markbates/goth"
+)
+
+// authParamsSessionKey prefixes the session key holding the authorization parameters of a provider's flow.
+const authParamsSessionKey = "_goth_params_"
+
+// ErrAuthParamNotHonored is returned by CheckAuthParams when the provider
+// did not honor a parameter of the authorization request.
+var ErrAuthParamNotHonored = errors.New("goth_fiber: authorization parameter not honored")
+
+// reservedAuthParams are set by the OAuth2 flow and are never passed through.
+var reservedAuthParams = map[string]bool{
+	"client_id":             true,
+	"redirect_uri":          true,
+	"response_type":         true,
+	"response_mode":         true,
+	"state":                 true,
+	"scope":                 true,
+	"nonce":                 true,
+	"code_challenge":        true,
+	"code_challenge_method": true,
+	"request":               true,
+	"request_uri":           true,
+}
+
+// GetContextWithAuthParams adds parameters, e.g. login_hint, to the
+// authorization URL built by GetAuthURL for the request. They override the
+// AuthParams and PassthroughParams of the ProviderOptions.
+func GetContextWithAuthParams(ctx fiber.Ctx, params url.Values) fiber.Ctx {
+	all := authParams(ctx)
+	if all == nil {
+		all = url.Values{}
+	}
+	for k, v := range params {
+		all[k] = v
+	}
+
+	ctx.Locals(authParamsKey, all)
+	return ctx
+}
+
+func authParams(ctx fiber.Ctx) url.Values {
+	params, _ := ctx.Locals(authParamsKey).(url.Values)
+	return params
+}
+
+// GetAuthParams returns the extra parameters the authorization request of
+// the flow was made with, in the begin request after GetAuthURL and in the
+// callback request after CompleteUserAuth.
+func GetAuthParams(ctx fiber.Ctx) url.Values {
+	params := authParams(ctx)
+	if params == nil {
+		return url.Values{}
+	}
+	return params
+}
+
+// buildAuthParams merges the AuthParams and PassthroughParams of opts and the
+// parameters set with GetContextWithAuthParams.
+func buildAuthParams(ctx fiber.Ctx, opts ProviderOptions) url.Values {
+	params := url.Values{}
+	for k, v := range opts.AuthParams {
+		params[k] = v
+	}
+
+	for _, name := range opts.PassthroughParams {
+		if value := ctx.Query(name); value != "" && !reservedAuthParams[name] {
+			params.Set(name, value)
+		}
+	}
+
+	for k, v := range authParams(ctx) {
+		params[k] = v
+	}
+
+	return params
+}
+
+// storeAuthParams records the parameters of the flow in the session,
+// replacing those of any earlier flow.
+func storeAuthParams(ctx fiber.Ctx, providerName string, params url.Values) error {
+	if len(params) == 0 {
+		return StoreInSession(authParamsSessionKey+providerName, "", ctx)
+	}
+
+	ctx.Locals(authParamsKey, params)
+
+	b, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+
+	return StoreInSession(authParamsSessionKey+providerName, string(b), ctx)
+}
+
+// loadAuthParams moves the parameters of the flow from the session to Locals,
+// so that they apply to this callback only.
+func loadAuthParams(ctx fiber.Ctx, providerName string) error {
+	value, err := GetFromSession(authParamsSessionKey+providerName, ctx)
+	if err != nil || value == "" {
+		return nil
+	}
+
+	var params url.Values
+	if json.Unmarshal([]byte(value), &params) == nil {
+		ctx.Locals(authParamsKey, params)
+	}
+
+	return StoreInSession(authParamsSessionKey+providerName, "", ctx)
+}
+
+/*
+CheckAuthParams verifies that the provider honored the parameters the
+authorization request was made with, according to the claims in the RawData
+of user:
+
+  - hd must match the "hd" claim
+  - acr_values must contain the "acr" claim
+  - max_age must not be older than the "auth_time" claim
+
+Other parameters are not checked. It returns an error wrapping
+ErrAuthParamNotHonored when a check fails.
+*/
+func CheckAuthParams(ctx fiber.Ctx, user goth.User) error {
+	params := GetAuthParams(ctx)
+
+	if hd := params.Get("hd"); hd != "" && hd != "*" {
+		if got := stringClaim(user.RawData, "hd"); got != hd {
+			return fmt.Errorf("%w: hd %q, got %q", ErrAuthParamNotHonored, hd, got)
+		}
+	}
+
+	if acrValues := params.Get("acr_values"); acrValues != "" {
+		acr := stringClaim(user.RawData, "acr")
+		if !containsField(acrValues, acr) {
+			return fmt.Errorf("%w: acr_values %q, got %q", ErrAuthParamNotHonored, acrValues, acr)
+		}
+	}
+
+	if maxAge := params.Get("max_age"); maxAge != "" {
+		seconds, err := strconv.ParseInt(maxAge, 10, 64)
+		if err != nil {
+			return fmt.Errorf("%w: invalid max_age %q", ErrAuthParamNotHonored, maxAge)
+		}
+
+		authTime, ok := numericClaim(user.RawData, "auth_time")
+		if !ok || time.Since(authTime) > time.Duration(seconds)*time.Second+time.Minute {
+			return fmt.Errorf("%w: max_age %s", ErrAuthParamNotHonored, maxAge)
+		}
+	}
+
+	return nil
+}
+
+// containsField reports whether the space separated list contains value.
+func containsField(list, value string) bool {
+	if value == "" {
+		return false
+	}
+	for _, field := range strings.Fields(list) {
+		if field == value {
+			return true
+		}
+	}
+	return false
+}
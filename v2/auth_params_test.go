@@ -0,0 +1,158 @@
+package goth_fiber
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/markbates/goth"
+	"github.com/markbates/goth/providers/faux"
+)
+
+func Test_GetAuthURL_AuthParams(t *testing.T) {
+	goth.ClearProviders()
+	goth.UseProviders(&faux.Provider{})
+	SetProviderOptions("faux", ProviderOptions{
+		AuthParams:        url.Values{"prompt": {"consent"}, "hd": {"example.com"}},
+		PassthroughParams: []string{"login_hint", "hd", "redirect_uri"},
+	})
+	defer SetProviderOptions("faux", ProviderOptions{})
+
+	app := fiber.New()
+	app.Get("/auth/:provider", BeginAuthHandler)
+	app.Get("/callback/:provider", func(c fiber.Ctx) error {
+		if _, err := CompleteUserAuth(c); err != nil {
+			return err
+		}
+		return c.SendString(GetAuthParams(c).Encode())
+	})
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/auth/faux?state=test-state&login_hint=jane&hd=acme.com&redirect_uri=https://evil.example.com", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	location, _ := url.Parse(resp.Header.Get("Location"))
+	query := location.Query()
+	if query.Get("prompt") != "consent" || query.Get("hd") != "acme.com" || query.Get("login_hint") != "jane" {
+		t.Errorf("expected the defaults and passthrough parameters, got %s", location.RawQuery)
+	}
+	if query.Get("redirect_uri") == "https://evil.example.com" {
+		t.Error("expected redirect_uri not to be passed through")
+	}
+
+	req := httptest.NewRequest("GET", "/callback/faux?code=test-code&state=test-state", nil)
+	for _, cookie := range resp.Cookies() {
+		req.AddCookie(cookie)
+	}
+	resp, err = app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, _ := io.ReadAll(resp.Body)
+	if got := string(body); got != "hd=acme.com&login_hint=jane&prompt=consent" {
+		t.Errorf("expected the parameters to be recorded for the callback, got %q", got)
+	}
+}
+
+func Test_CheckAuthParams(t *testing.T) {
+	t.Parallel()
+
+	app := fiber.New()
+	app.Get("/", func(c fiber.Ctx) error {
+		GetContextWithAuthParams(c, url.Values{
+			"hd":         {"acme.com"},
+			"acr_values": {"mfa phr"},
+			"max_age":    {"300"},
+		})
+
+		user := goth.User{RawData: map[string]interface{}{
+			"hd":        "acme.com",
+			"acr":       "mfa",
+			"auth_time": float64(time.Now().Add(-time.Minute).Unix()),
+		}}
+		if err := CheckAuthParams(c, user); err != nil {
+			t.Errorf("expected the parameters to be honored, got %v", err)
+		}
+
+		for claim, value := range map[string]interface{}{
+			"hd":        "gmail.com",
+			"acr":       "pwd",
+			"auth_time": float64(time.Now().Add(-time.Hour).Unix()),
+		} {
+			raw := map[string]interface{}{}
+			for k, v := range user.RawData {
+				raw[k] = v
+			}
+			raw[claim] = value
+
+			if err := CheckAuthParams(c, goth.User{RawData: raw}); !errors.Is(err, ErrAuthParamNotHonored) {
+				t.Errorf("expected ErrAuthParamNotHonored for %s, got %v", claim, err)
+			}
+		}
+		return nil
+	})
+
+	if _, err := app.Test(httptest.NewRequest("GET", "/", nil)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_AuthParams_NotReused(t *testing.T) {
+	goth.ClearProviders()
+	goth.UseProviders(&faux.Provider{})
+	SetProviderOptions("faux", ProviderOptions{PassthroughParams: []string{"max_age"}})
+	defer SetProviderOptions("faux", ProviderOptions{})
+
+	app := fiber.New()
+	app.Get("/auth/:provider", BeginAuthHandler)
+	app.Get("/callback/:provider", func(c fiber.Ctx) error {
+		if _, err := CompleteUserAuth(c, CompleteUserAuthOptions{ShouldLogout: false}); err != nil {
+			return err
+		}
+		return c.SendString(GetAuthParams(c).Encode())
+	})
+	app.Get("/stored/:provider", func(c fiber.Ctx) error {
+		value, _ := GetFromSession(authParamsSessionKey+c.Params("provider"), c)
+		return c.SendString(value)
+	})
+
+	var cookies []*http.Cookie
+	get := func(path string) string {
+		t.Helper()
+
+		req := httptest.NewRequest("GET", path, nil)
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c := resp.Cookies(); len(c) > 0 {
+			cookies = c
+		}
+		body, _ := io.ReadAll(resp.Body)
+		return string(body)
+	}
+
+	get("/auth/faux?state=test-state&max_age=60")
+	get("/auth/faux?state=test-state")
+	if got := get("/callback/faux?code=test-code&state=test-state"); got != "" {
+		t.Errorf("expected the parameters of the abandoned flow to be replaced, got %q", got)
+	}
+
+	get("/auth/faux?state=test-state&max_age=60")
+	if got := get("/callback/faux?code=test-code&state=test-state"); got != "max_age=60" {
+		t.Errorf("unexpected parameters %q", got)
+	}
+	if got := get("/stored/faux"); got != "" {
+		t.Errorf("expected the parameters to be cleared once consumed, got %q", got)
+	}
+}
@@ -0,0 +1,306 @@
+package goth_fiber
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/markbates/goth"
+)
+
+// ErrMissingToken is returned when a request carries neither a bearer token nor a session user.
+var ErrMissingToken = errors.New("goth_fiber: missing bearer token")
+
+// bearerEvictInterval is how often expired validation results are dropped.
+const bearerEvictInterval = time.Minute
+
+// Options that affect how BearerAuth works.
+type BearerAuthOptions struct {
+	// IntrospectionURL is the RFC 7662 token introspection endpoint used to
+	// validate opaque tokens. ClientID and ClientSecret authenticate the call.
+	IntrospectionURL string
+	ClientID         string
+	ClientSecret     string
+
+	// JWKSURL is fetched for the keys used to verify JWT bearer tokens locally.
+	JWKSURL string
+
+	// Keys verify JWT bearer tokens locally, by key id. A key is a []byte
+	// secret for the HS algorithms or an RSA, ECDSA or Ed25519 public key.
+	// The empty key id matches tokens without a "kid" header.
+	Keys map[string]interface{}
+
+	// Issuer and Audience, when set, must match the "iss" and "aud" claims.
+	Issuer   string
+	Audience string
+
+	// Leeway allowed when checking "exp" and "nbf".
+	Leeway time.Duration
+
+	// Provider is reported as the Provider of the resulting user.
+	//
+	// Defaults to "bearer".
+	Provider string
+
+	// UserFromClaims builds the user from the validated claims of a token.
+	//
+	// Defaults to mapping the standard OIDC claims.
+	UserFromClaims func(token string, claims map[string]interface{}) goth.User
+
+	// SessionFallback lets requests without a bearer token through when the
+	// session holds a user stored by CompleteUserAuth.
+	SessionFallback bool
+
+	// HTTPClient used for introspection and JWKS requests.
+	//
+	// Defaults to http.DefaultClient.
+	HTTPClient *http.Client
+
+	// Unauthorized is called when the request cannot be authenticated.
+	//
+	// Defaults to responding with 401 Unauthorized and a WWW-Authenticate header.
+	Unauthorized func(ctx fiber.Ctx, err error) error
+}
+
+/*
+BearerAuth returns a middleware for resource servers. It validates the bearer
+token of the request, locally as a JWT when Keys or JWKSURL are configured and
+through token introspection otherwise, and makes the resulting user available
+through CurrentUser, just like a session authenticated user. JWTs without an
+"exp" claim are rejected, as they would stay valid forever.
+
+Validated tokens are cached until they expire. Please note that any options
+provided in addition to the first will be ignored.
+*/
+func BearerAuth(options ...BearerAuthOptions) fiber.Handler {
+	var opts BearerAuthOptions
+	if len(options) > 0 {
+		opts = options[0]
+	}
+
+	if opts.Provider == "" {
+		opts.Provider = "bearer"
+	}
+
+	if opts.HTTPClient == nil {
+		opts.HTTPClient = http.DefaultClient
+	}
+
+	if opts.Unauthorized == nil {
+		opts.Unauthorized = func(ctx fiber.Ctx, err error) error {
+			ctx.Set(fiber.HeaderWWWAuthenticate, `Bearer error="invalid_token"`)
+			return ctx.Status(fiber.StatusUnauthorized).SendString(err.Error())
+		}
+	}
+
+	v := &bearerValidator{opts: opts, cache: map[[32]byte]bearerCacheEntry{}}
+	if opts.JWKSURL != "" {
+		v.jwks = newJWKSCache(opts.JWKSURL, opts.HTTPClient)
+	}
+
+	return func(ctx fiber.Ctx) error {
+		token := bearerToken(ctx)
+		if token == "" {
+			if opts.SessionFallback {
+				if _, err := CurrentUser(ctx); err == nil {
+					return ctx.Next()
+				}
+			}
+
+			return opts.Unauthorized(ctx, ErrMissingToken)
+		}
+
+		user, err := v.validate(token)
+		if err != nil {
+			return opts.Unauthorized(ctx, err)
+		}
+
+		ctx.Locals(userKey, user)
+		return ctx.Next()
+	}
+}
+
+// bearerToken returns the token of an "Authorization: Bearer" header.
+func bearerToken(ctx fiber.Ctx) string {
+	auth := ctx.Get(fiber.HeaderAuthorization)
+	if len(auth) > 7 && strings.EqualFold(auth[:7], "bearer ") {
+		return strings.TrimSpace(auth[7:])
+	}
+
+	return ""
+}
+
+// bearerValidator validates bearer tokens and caches the results.
+type bearerValidator struct {
+	opts BearerAuthOptions
+	jwks *jwksCache
+
+	mu      sync.Mutex
+	cache   map[[32]byte]bearerCacheEntry
+	evicted time.Time
+}
+
+type bearerCacheEntry struct {
+	user    goth.User
+	expires time.Time
+}
+
+func (v *bearerValidator) validate(token string) (goth.User, error) {
+	id := sha256.Sum256([]byte(token))
+
+	v.mu.Lock()
+	entry, ok := v.cache[id]
+	if ok && !time.Now().Before(entry.expires) {
+		delete(v.cache, id)
+		ok = false
+	}
+	v.mu.Unlock()
+	if ok {
+		return entry.user, nil
+	}
+
+	var claims map[string]interface{}
+	var err error
+	if v.jwks != nil || v.opts.Keys != nil {
+		claims, err = v.verifyJWT(token)
+	} else if v.opts.IntrospectionURL != "" {
+		claims, err = v.introspect(token)
+	} else {
+		err = errors.New("goth_fiber: BearerAuth needs Keys, JWKSURL or IntrospectionURL")
+	}
+	if err != nil {
+		return goth.User{}, err
+	}
+
+	var user goth.User
+	if v.opts.UserFromClaims != nil {
+		user = v.opts.UserFromClaims(token, claims)
+	} else {
+		user = v.userFromClaims(token, claims)
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.evictExpired()
+	if exp, ok := numericClaim(claims, "exp"); ok {
+		v.cache[id] = bearerCacheEntry{user: user, expires: exp}
+	}
+
+	return user, nil
+}
+
+func (v *bearerValidator) verifyJWT(token string) (map[string]interface{}, error) {
+	t, err := parseJWT(token)
+	if err != nil {
+		return nil, err
+	}
+
+	key, ok := v.opts.Keys[t.header.Kid]
+	if !ok {
+		if v.jwks == nil {
+			return nil, fmt.Errorf("%w: unknown key id %q", ErrInvalidToken, t.header.Kid)
+		}
+		if key, err = v.jwks.key(t.header.Kid); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := t.verify(key); err != nil {
+		return nil, err
+	}
+
+	if err := t.validateClaims(v.opts.Issuer, v.opts.Audience, v.opts.Leeway); err != nil {
+		return nil, err
+	}
+
+	if _, ok := numericClaim(t.claims, "exp"); !ok {
+		return nil, fmt.Errorf("%w: token does not expire", ErrInvalidToken)
+	}
+
+	return t.claims, nil
+}
+
+func (v *bearerValidator) introspect(token string) (map[string]interface{}, error) {
+	form := url.Values{"token": {token}, "token_type_hint": {"access_token"}}
+	req, err := http.NewRequest(http.MethodPost, v.opts.IntrospectionURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationForm)
+	req.Header.Set(fiber.HeaderAccept, fiber.MIMEApplicationJSON)
+	if v.opts.ClientID != "" {
+		req.SetBasicAuth(url.QueryEscape(v.opts.ClientID), url.QueryEscape(v.opts.ClientSecret))
+	}
+
+	resp, err := v.opts.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("goth_fiber: token introspection returned %s", resp.Status)
+	}
+
+	var claims map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&claims); err != nil {
+		return nil, err
+	}
+
+	if active, _ := claims["active"].(bool); !active {
+		return nil, fmt.Errorf("%w: token is not active", ErrInvalidToken)
+	}
+
+	t := parsedJWT{claims: claims}
+	if err := t.validateClaims(v.opts.Issuer, v.opts.Audience, v.opts.Leeway); err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+func (v *bearerValidator) userFromClaims(token string, claims map[string]interface{}) goth.User {
+	user := goth.User{
+		RawData:     claims,
+		Provider:    v.opts.Provider,
+		UserID:      stringClaim(claims, "sub"),
+		Email:       stringClaim(claims, "email"),
+		Name:        stringClaim(claims, "name"),
+		NickName:    stringClaim(claims, "preferred_username"),
+		AccessToken: token,
+	}
+
+	if user.NickName == "" {
+		user.NickName = stringClaim(claims, "username")
+	}
+
+	if exp, ok := numericClaim(claims, "exp"); ok {
+		user.ExpiresAt = exp
+	}
+
+	return user
+}
+
+// evictExpired drops expired entries at most every bearerEvictInterval, so
+// that the cache is not scanned for every new token. Expired entries that are
+// looked up are dropped right away. The caller must hold v.mu.
+func (v *bearerValidator) evictExpired() {
+	now := time.Now()
+	if now.Sub(v.evicted) < bearerEvictInterval {
+		return
+	}
+	v.evicted = now
+
+	for id, entry := range v.cache {
+		if now.After(entry.expires) {
+			delete(v.cache, id)
+		}
+	}
+}
@@ -0,0 +1,248 @@
+package goth_fiber
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/markbates/goth"
+)
+
+func bearerApp(opts BearerAuthOptions) *fiber.App {
+	app := fiber.New()
+	app.Get("/login", func(c fiber.Ctx) error {
+		return StoreUserInSession(goth.User{Provider: "faux", UserID: "session-user"}, c)
+	})
+	app.Get("/me", BearerAuth(opts), func(c fiber.Ctx) error {
+		user, err := CurrentUser(c)
+		if err != nil {
+			return c.Status(500).SendString(err.Error())
+		}
+		return c.SendString(user.Provider + ":" + user.UserID)
+	})
+	return app
+}
+
+func bearerRequest(t *testing.T, app *fiber.App, token string) (int, string) {
+	t.Helper()
+
+	req := httptest.NewRequest("GET", "/me", nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(body)
+}
+
+func Test_BearerAuth_JWKS(t *testing.T) {
+	t.Parallel()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwk, err := publicJWK(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwk.Kid = "k1"
+
+	jwks := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"keys": []jsonWebKey{jwk}})
+	}))
+	defer jwks.Close()
+
+	app := bearerApp(BearerAuthOptions{JWKSURL: jwks.URL, Issuer: "https://issuer", Audience: "api"})
+
+	token, err := signJWT(jwtHeader{Alg: "ES256", Kid: "k1"}, map[string]interface{}{
+		"sub": "42",
+		"iss": "https://issuer",
+		"aud": "api",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if status, body := bearerRequest(t, app, token); status != 200 || body != "bearer:42" {
+		t.Errorf("expected bearer user, got %d: %s", status, body)
+	}
+
+	expired, err := signJWT(jwtHeader{Alg: "ES256", Kid: "k1"}, map[string]interface{}{
+		"sub": "42",
+		"iss": "https://issuer",
+		"aud": "api",
+		"exp": time.Now().Add(-time.Hour).Unix(),
+	}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if status, _ := bearerRequest(t, app, expired); status != fiber.StatusUnauthorized {
+		t.Errorf("expected status %d for expired token, got %d", fiber.StatusUnauthorized, status)
+	}
+
+	unbounded, err := signJWT(jwtHeader{Alg: "ES256", Kid: "k1"}, map[string]interface{}{
+		"sub": "42",
+		"iss": "https://issuer",
+		"aud": "api",
+	}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if status, _ := bearerRequest(t, app, unbounded); status != fiber.StatusUnauthorized {
+		t.Errorf("expected status %d for token without exp, got %d", fiber.StatusUnauthorized, status)
+	}
+}
+
+func Test_JWKSCache_ConcurrentFetch(t *testing.T) {
+	t.Parallel()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwk, err := publicJWK(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwk.Kid = "k1"
+
+	var fetches int32
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	jwks := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		started <- struct{}{}
+		<-release
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"keys": []jsonWebKey{jwk}})
+	}))
+	defer jwks.Close()
+
+	cache := newJWKSCache(jwks.URL, nil)
+	cache.keys = map[string]interface{}{"k0": &key.PublicKey}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for n := 0; n < 10; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := cache.key("k1")
+			errs <- err
+		}()
+	}
+
+	// known keys are served while the set is being fetched
+	<-started
+	known := make(chan error, 1)
+	go func() {
+		_, err := cache.key("k0")
+		known <- err
+	}()
+	select {
+	case err := <-known:
+		if err != nil {
+			t.Errorf("expected the cached key during the fetch, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("expected the cached key not to wait for the fetch")
+	}
+
+	close(release)
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Errorf("expected the fetched key, got %v", err)
+		}
+	}
+	if n := atomic.LoadInt32(&fetches); n != 1 {
+		t.Errorf("expected the set to be fetched once, got %d fetches", n)
+	}
+}
+
+func Test_BearerAuth_IntrospectionIsCached(t *testing.T) {
+	t.Parallel()
+
+	var calls int32
+	introspection := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		if id, secret, _ := r.BasicAuth(); id != "rs" || secret != "rs-secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_ = r.ParseForm()
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"active": r.PostForm.Get("token") == "opaque",
+			"sub":    "svc",
+			"exp":    time.Now().Add(time.Hour).Unix(),
+		})
+	}))
+	defer introspection.Close()
+
+	app := bearerApp(BearerAuthOptions{
+		IntrospectionURL: introspection.URL,
+		ClientID:         "rs",
+		ClientSecret:     "rs-secret",
+	})
+
+	for i := 0; i < 3; i++ {
+		if status, body := bearerRequest(t, app, "opaque"); status != 200 || body != "bearer:svc" {
+			t.Fatalf("expected introspected user, got %d: %s", status, body)
+		}
+	}
+
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Errorf("expected a single introspection call, got %d", n)
+	}
+
+	if status, _ := bearerRequest(t, app, "revoked"); status != fiber.StatusUnauthorized {
+		t.Errorf("expected status %d for inactive token, got %d", fiber.StatusUnauthorized, status)
+	}
+}
+
+func Test_BearerAuth_SessionFallback(t *testing.T) {
+	t.Parallel()
+
+	app := bearerApp(BearerAuthOptions{Keys: map[string]interface{}{"": []byte("secret")}, SessionFallback: true})
+
+	if status, _ := bearerRequest(t, app, ""); status != fiber.StatusUnauthorized {
+		t.Fatalf("expected status %d without token or session, got %d", fiber.StatusUnauthorized, status)
+	}
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/login", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest("GET", "/me", nil)
+	for _, cookie := range resp.Cookies() {
+		req.AddCookie(cookie)
+	}
+	resp, err = app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != "faux:session-user" {
+		t.Errorf("expected session user, got %d: %s", resp.StatusCode, string(body))
+	}
+}
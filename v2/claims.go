@@ -0,0 +1,276 @@
+package goth_fiber
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/markbates/goth"
+)
+
+const (
+	// RolesKey is the RawData key CompleteUserAuth stores the normalized roles of the user under.
+	RolesKey = "goth_fiber_roles"
+
+	// GroupsKey is the RawData key CompleteUserAuth stores the normalized groups of the user under.
+	GroupsKey = "goth_fiber_groups"
+)
+
+var (
+	// ErrForbidden is returned by the Require middlewares when the user does
+	// not meet the requirement.
+	ErrForbidden = errors.New("goth_fiber: forbidden")
+
+	// ErrNotAuthenticated is returned by the Require middlewares when the
+	// request has no user.
+	ErrNotAuthenticated = errors.New("goth_fiber: not authenticated")
+)
+
+// AuthorizationErrorHandler responds to requests rejected by the Require
+// middlewares, with ErrForbidden, ErrNotAuthenticated, ErrInsufficientScope
+// (RequireScopes) or ErrStaleAuth (RequireFreshAuth).
+var AuthorizationErrorHandler = func(ctx fiber.Ctx, err error) error {
+	return ctx.Status(ErrorStatus(err)).SendString(err.Error())
+}
+
+// UserClaims are the roles and groups of a user, normalized across providers.
+type UserClaims struct {
+	Roles  []string
+	Groups []string
+}
+
+// ClaimsMapper extracts the roles and groups of a user from its provider data.
+type ClaimsMapper func(user goth.User) (UserClaims, error)
+
+/*
+DefaultClaimsMapper reads the claims most providers use in their RawData:
+"roles" (Azure AD app roles), "groups" (Okta, Azure AD) and "hd" (the Google
+hosted domain, as the group "hd:<domain>").
+*/
+func DefaultClaimsMapper(user goth.User) (UserClaims, error) {
+	claims := UserClaims{
+		Roles:  claimValues(user.RawData, "roles"),
+		Groups: claimValues(user.RawData, "groups"),
+	}
+
+	if hd := stringClaim(user.RawData, "hd"); hd != "" {
+		claims.Groups = append(claims.Groups, "hd:"+hd)
+	}
+
+	return claims, nil
+}
+
+// githubAPIURL is the base URL of the GitHub API.
+var githubAPIURL = "https://api.github.com"
+
+// GitHubClaimsMapper returns a ClaimsMapper fetching the organizations of
+// GitHub users as their groups, which needs the read:org scope. Requests are
+// sent with client, or http.DefaultClient when nil.
+func GitHubClaimsMapper(client *http.Client) ClaimsMapper {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	return func(user goth.User) (UserClaims, error) {
+		req, err := http.NewRequest(http.MethodGet, githubAPIURL+"/user/orgs", nil)
+		if err != nil {
+			return UserClaims{}, err
+		}
+		req.Header.Set("Authorization", "Bearer "+user.AccessToken)
+		req.Header.Set("Accept", "application/vnd.github+json")
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return UserClaims{}, err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return UserClaims{}, fmt.Errorf("goth_fiber: fetching GitHub organizations returned %s", resp.Status)
+		}
+
+		var orgs []struct {
+			Login string `json:"login"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&orgs); err != nil {
+			return UserClaims{}, err
+		}
+
+		var claims UserClaims
+		for _, org := range orgs {
+			claims.Groups = append(claims.Groups, org.Login)
+		}
+		return claims, nil
+	}
+}
+
+// mapClaims stores the roles and groups of user, as found by the
+// ClaimsMapper of the provider, in its RawData.
+func mapClaims(ctx fiber.Ctx, providerName string, user goth.User) (goth.User, error) {
+	mapper := requestProviderOptions(ctx, providerName).ClaimsMapper
+	if mapper == nil {
+		mapper = DefaultClaimsMapper
+	}
+
+	claims, err := mapper(user)
+	if err != nil {
+		return user, err
+	}
+
+	raw := make(map[string]interface{}, len(user.RawData)+2)
+	for k, v := range user.RawData {
+		raw[k] = v
+	}
+	raw[RolesKey] = claims.Roles
+	raw[GroupsKey] = claims.Groups
+	user.RawData = raw
+
+	return user, nil
+}
+
+// UserRoles returns the normalized roles of user. Users that did not go
+// through CompleteUserAuth, e.g. from BearerAuth, are mapped with
+// DefaultClaimsMapper.
+func UserRoles(user goth.User) []string {
+	if _, ok := user.RawData[RolesKey]; ok {
+		return claimValues(user.RawData, RolesKey)
+	}
+
+	claims, _ := DefaultClaimsMapper(user)
+	return claims.Roles
+}
+
+// UserGroups returns the normalized groups of user, see UserRoles.
+func UserGroups(user goth.User) []string {
+	if _, ok := user.RawData[GroupsKey]; ok {
+		return claimValues(user.RawData, GroupsKey)
+	}
+
+	claims, _ := DefaultClaimsMapper(user)
+	return claims.Groups
+}
+
+// claimValues returns a claim holding a string, a list of strings or a
+// comma separated string as a list.
+func claimValues(claims map[string]interface{}, name string) []string {
+	switch v := claims[name].(type) {
+	case string:
+		var values []string
+		for _, s := range strings.Split(v, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				values = append(values, s)
+			}
+		}
+		return values
+	case []string:
+		return v
+	case []interface{}:
+		var values []string
+		for _, s := range v {
+			if s, ok := s.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	case nil:
+		return nil
+	default:
+		return []string{fmt.Sprint(v)}
+	}
+}
+
+// Requirement is a condition on the current user, for Require.
+type Requirement func(user goth.User) bool
+
+// HasRole requires the user to have any of roles.
+func HasRole(roles ...string) Requirement {
+	return func(user goth.User) bool {
+		return containsAny(UserRoles(user), roles)
+	}
+}
+
+// InGroup requires the user to be in any of groups.
+func InGroup(groups ...string) Requirement {
+	return func(user goth.User) bool {
+		return containsAny(UserGroups(user), groups)
+	}
+}
+
+// HasClaim requires the RawData claim name of the user to hold any of
+// values, or to be set when no values are given.
+func HasClaim(name string, values ...string) Requirement {
+	return func(user goth.User) bool {
+		got := claimValues(user.RawData, name)
+		if len(values) == 0 {
+			return len(got) > 0
+		}
+		return containsAny(got, values)
+	}
+}
+
+// AllOf requires all of requirements.
+func AllOf(requirements ...Requirement) Requirement {
+	return func(user goth.User) bool {
+		for _, requirement := range requirements {
+			if !requirement(user) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// AnyOf requires any of requirements.
+func AnyOf(requirements ...Requirement) Requirement {
+	return func(user goth.User) bool {
+		for _, requirement := range requirements {
+			if requirement(user) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+/*
+Require returns a middleware letting requests through only when the current
+user, see CurrentUser, meets requirement. Rejected requests are handed to
+AuthorizationErrorHandler with ErrNotAuthenticated or ErrForbidden.
+*/
+func Require(requirement Requirement) fiber.Handler {
+	return func(ctx fiber.Ctx) error {
+		user, err := CurrentUser(ctx)
+		if err != nil {
+			return AuthorizationErrorHandler(ctx, fmt.Errorf("%w: %w", ErrNotAuthenticated, err))
+		}
+
+		if !requirement(user) {
+			return AuthorizationErrorHandler(ctx, ErrForbidden)
+		}
+
+		return ctx.Next()
+	}
+}
+
+// RequireRole returns a middleware requiring the user to have any of roles.
+func RequireRole(roles ...string) fiber.Handler {
+	return Require(HasRole(roles...))
+}
+
+// RequireClaim returns a middleware requiring the RawData claim name of the
+// user to hold any of values, see HasClaim.
+func RequireClaim(name string, values ...string) fiber.Handler {
+	return Require(HasClaim(name, values...))
+}
+
+func containsAny(list, values []string) bool {
+	for _, value := range values {
+		if containsString(list, value) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,202 @@
+package goth_fiber
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/markbates/goth"
+	"github.com/markbates/goth/providers/faux"
+)
+
+func Test_DefaultClaimsMapper(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name   string
+		raw    map[string]interface{}
+		roles  []string
+		groups []string
+	}{
+		{"azure", map[string]interface{}{"roles": []interface{}{"Admin"}, "groups": []interface{}{"0b1f-..."}}, []string{"Admin"}, []string{"0b1f-..."}},
+		{"okta", map[string]interface{}{"groups": []interface{}{"Everyone", "Engineering"}}, nil, []string{"Everyone", "Engineering"}},
+		{"google", map[string]interface{}{"hd": "example.com"}, nil, []string{"hd:example.com"}},
+		{"comma separated", map[string]interface{}{"roles": "admin, billing"}, []string{"admin", "billing"}, nil},
+	}
+
+	for _, tt := range tests {
+		claims, err := DefaultClaimsMapper(goth.User{RawData: tt.raw})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(claims.Roles, tt.roles) || !reflect.DeepEqual(claims.Groups, tt.groups) {
+			t.Errorf("%s: unexpected claims %+v", tt.name, claims)
+		}
+	}
+}
+
+func Test_Require(t *testing.T) {
+	t.Parallel()
+
+	user := goth.User{RawData: map[string]interface{}{
+		RolesKey:  []interface{}{"editor"},
+		GroupsKey: []interface{}{"staff"},
+		"tier":    "gold",
+	}}
+
+	tests := []struct {
+		name       string
+		middleware fiber.Handler
+		anonymous  bool
+		wantStatus int
+	}{
+		{"role", RequireRole("admin", "editor"), false, fiber.StatusOK},
+		{"missing role", RequireRole("admin"), false, fiber.StatusForbidden},
+		{"claim", RequireClaim("tier", "gold", "platinum"), false, fiber.StatusOK},
+		{"claim set", RequireClaim("tier"), false, fiber.StatusOK},
+		{"missing claim", RequireClaim("tier", "platinum"), false, fiber.StatusForbidden},
+		{"all of", Require(AllOf(HasRole("editor"), InGroup("staff"))), false, fiber.StatusOK},
+		{"all of failing", Require(AllOf(HasRole("editor"), InGroup("admins"))), false, fiber.StatusForbidden},
+		{"any of", Require(AnyOf(HasRole("admin"), HasClaim("tier", "gold"))), false, fiber.StatusOK},
+		{"anonymous", RequireRole("editor"), true, fiber.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		anonymous := tt.anonymous
+		app := fiber.New()
+		app.Get("/", func(c fiber.Ctx) error {
+			if !anonymous {
+				c.Locals(userKey, user)
+			}
+			return c.Next()
+		}, tt.middleware, func(c fiber.Ctx) error {
+			return c.SendString("ok")
+		})
+
+		resp, err := app.Test(httptest.NewRequest("GET", "/", nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != tt.wantStatus {
+			t.Errorf("%s: expected %d, got %d", tt.name, tt.wantStatus, resp.StatusCode)
+		}
+	}
+}
+
+func Test_AuthorizationErrorHandler(t *testing.T) {
+	original := AuthorizationErrorHandler
+	defer func() { AuthorizationErrorHandler = original }()
+	AuthorizationErrorHandler = func(c fiber.Ctx, err error) error {
+		return c.Status(ErrorStatus(err)).SendString(ErrorCode(err))
+	}
+
+	app := fiber.New()
+	app.Get("/login", func(c fiber.Ctx) error {
+		return StoreUserInSession(goth.User{Provider: "faux", UserID: "1"}, c)
+	})
+	app.All("/fresh", RequireFreshAuth(time.Minute, ""), func(c fiber.Ctx) error { return c.SendString("ok") })
+	app.Get("/scoped", RequireScopes("drive"), func(c fiber.Ctx) error { return c.SendString("ok") })
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/login", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookies := resp.Cookies()
+
+	tests := []struct {
+		method, path string
+		session      bool
+		status       int
+		code         string
+	}{
+		{"GET", "/fresh", false, fiber.StatusUnauthorized, "unauthenticated"},
+		{"POST", "/fresh", true, fiber.StatusUnauthorized, "login_required"},
+		{"GET", "/scoped", false, fiber.StatusUnauthorized, "unauthenticated"},
+		{"GET", "/scoped", true, fiber.StatusForbidden, "insufficient_scope"},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.path, nil)
+		if tt.session {
+			for _, cookie := range cookies {
+				req.AddCookie(cookie)
+			}
+		}
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		body, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != tt.status || string(body) != tt.code {
+			t.Errorf("%s %s: expected %d %s, got %d %s", tt.method, tt.path, tt.status, tt.code, resp.StatusCode, body)
+		}
+	}
+}
+
+func Test_CompleteUserAuth_MapsClaims(t *testing.T) {
+	goth.ClearProviders()
+	goth.UseProviders(&faux.Provider{})
+	SetProviderOptions("faux", ProviderOptions{
+		ClaimsMapper: func(user goth.User) (UserClaims, error) {
+			return UserClaims{Roles: []string{"admin"}}, nil
+		},
+	})
+	defer SetProviderOptions("faux", ProviderOptions{})
+
+	app := fiber.New()
+	app.Get("/auth/:provider", BeginAuthHandler)
+	app.Get("/callback/:provider", func(c fiber.Ctx) error {
+		if _, err := CompleteUserAuth(c, CompleteUserAuthOptions{ShouldLogout: false}); err != nil {
+			return err
+		}
+		return c.Next()
+	}, RequireRole("admin"), func(c fiber.Ctx) error {
+		return c.SendString("ok")
+	})
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/auth/faux?state=test-state", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest("GET", "/callback/faux?code=test-code&state=test-state", nil)
+	for _, cookie := range resp.Cookies() {
+		req.AddCookie(cookie)
+	}
+	resp, err = app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != fiber.StatusOK {
+		t.Errorf("expected the mapped role to be stored on the user, got %d", resp.StatusCode)
+	}
+}
+
+func Test_GitHubClaimsMapper(t *testing.T) {
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/user/orgs" || r.Header.Get("Authorization") != "Bearer gh-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_ = json.NewEncoder(w).Encode([]map[string]string{{"login": "acme"}, {"login": "golang"}})
+	}))
+	defer api.Close()
+
+	defaultURL := githubAPIURL
+	githubAPIURL = api.URL
+	defer func() { githubAPIURL = defaultURL }()
+
+	claims, err := GitHubClaimsMapper(nil)(goth.User{AccessToken: "gh-token"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(claims.Groups, []string{"acme", "golang"}) {
+		t.Errorf("expected the organizations as groups, got %v", claims.Groups)
+	}
+}
@@ -0,0 +1,88 @@
+package goth_fiber
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// clientAssertionType is the client_assertion_type of private_key_jwt client authentication.
+const clientAssertionType = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+
+/*
+setClientAssertion replaces the client secret of a token endpoint form by a
+private_key_jwt client assertion (RFC 7523) for clientID, with audience as
+its "aud" claim. The caller must not send the client secret in a header.
+*/
+func setClientAssertion(form url.Values, opts ProviderOptions, clientID, audience string) error {
+	alg := opts.ClientAssertionAlgorithm
+	if alg == "" {
+		var err error
+		if alg, err = defaultAlgorithm(opts.ClientAssertionKey); err != nil {
+			return err
+		}
+	}
+
+	lifetime := opts.ClientAssertionLifetime
+	if lifetime == 0 {
+		lifetime = time.Minute
+	}
+
+	jti, err := randomToken()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	assertion, err := signJWT(jwtHeader{Alg: alg, Kid: opts.ClientAssertionKeyID}, map[string]interface{}{
+		"iss": clientID,
+		"sub": clientID,
+		"aud": audience,
+		"jti": jti,
+		"iat": now.Unix(),
+		"exp": now.Add(lifetime).Unix(),
+	}, opts.ClientAssertionKey)
+	if err != nil {
+		return err
+	}
+
+	form.Del("client_secret")
+	form.Set("client_id", clientID)
+	form.Set("client_assertion_type", clientAssertionType)
+	form.Set("client_assertion", assertion)
+	return nil
+}
+
+// authenticateClient prepares form for the client authentication configured
+// in opts and returns the client secret to send in the basic auth header, if any.
+func authenticateClient(form url.Values, opts ProviderOptions, clientID, audience string) (string, error) {
+	if opts.ClientAssertionKey == nil {
+		return opts.ClientSecret, nil
+	}
+
+	return "", setClientAssertion(form, opts, clientID, audience)
+}
+
+// defaultAlgorithm returns the JWS algorithm matching a private key.
+func defaultAlgorithm(key interface{}) (string, error) {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return "RS256", nil
+	case *ecdsa.PrivateKey:
+		switch k.Curve.Params().BitSize {
+		case 256:
+			return "ES256", nil
+		case 384:
+			return "ES384", nil
+		case 521:
+			return "ES512", nil
+		}
+	case ed25519.PrivateKey:
+		return "EdDSA", nil
+	}
+
+	return "", fmt.Errorf("goth_fiber: unsupported private key type %T", key)
+}
@@ -0,0 +1,127 @@
+package goth_fiber
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/markbates/goth"
+)
+
+func Test_ClientAssertion_CodeExchangeAndRefresh(t *testing.T) {
+	server := newFakeAuthServer(t)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	SetProviderOptions("assertion", ProviderOptions{
+		TokenURL:                server.URL + "/token",
+		ClientAssertionKey:      key,
+		ClientAssertionKeyID:    "key-1",
+		ClientAssertionLifetime: 30 * time.Second,
+	})
+	defer SetProviderOptions("assertion", ProviderOptions{})
+
+	// another provider of the same server must not lend its options
+	other, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetProviderOptions("other", ProviderOptions{
+		TokenURL:             server.URL + "/token",
+		ClientAssertionKey:   other,
+		ClientAssertionKeyID: "key-2",
+	})
+	defer SetProviderOptions("other", ProviderOptions{})
+
+	var provider *testOAuth2Provider
+	_, _, err = LoopbackLogin(context.Background(), LoopbackOptions{
+		Provider: func(redirectURL string, client *http.Client) (goth.Provider, error) {
+			provider = newTestOAuth2Provider(server, "assertion", redirectURL, client)
+			return provider, nil
+		},
+		Browser: testBrowser(t, server),
+		Output:  io.Discard,
+		Timeout: 5 * time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkAssertion := func(grantType string) {
+		t.Helper()
+
+		form := server.lastTokenForm()
+		if form.Get("grant_type") != grantType {
+			t.Fatalf("expected a %s request, got %v", grantType, form)
+		}
+		if form.Get("client_secret") != "" || form.Get("client_assertion_type") != clientAssertionType {
+			t.Fatalf("expected a client assertion instead of the client secret, got %v", form)
+		}
+
+		token, err := parseJWT(form.Get("client_assertion"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token.header.Alg != "ES256" || token.header.Kid != "key-1" {
+			t.Errorf("unexpected assertion header %+v", token.header)
+		}
+		if err := token.verify(&key.PublicKey); err != nil {
+			t.Fatal(err)
+		}
+		if err := token.validateClaims("client", server.URL+"/token", 0); err != nil {
+			t.Fatal(err)
+		}
+
+		iat, _ := numericClaim(token.claims, "iat")
+		exp, _ := numericClaim(token.claims, "exp")
+		if exp.Sub(iat) != 30*time.Second || stringClaim(token.claims, "sub") != "client" || stringClaim(token.claims, "jti") == "" {
+			t.Errorf("unexpected assertion claims %v", token.claims)
+		}
+	}
+
+	checkAssertion("authorization_code")
+
+	goth.ClearProviders()
+	goth.UseProviders(provider)
+
+	app := fiber.New()
+	app.Get("/login", func(c fiber.Ctx) error {
+		return StoreUserInSession(goth.User{
+			Provider:     "assertion",
+			AccessToken:  "stale",
+			RefreshToken: "rt",
+			ExpiresAt:    time.Now().Add(-time.Minute),
+		}, c)
+	})
+	app.Get("/token", func(c fiber.Ctx) error {
+		ts, err := TokenSource(c)
+		if err != nil {
+			return err
+		}
+		_, err = ts.Token()
+		return err
+	})
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/login", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest("GET", "/token", nil)
+	for _, cookie := range resp.Cookies() {
+		req.AddCookie(cookie)
+	}
+	if resp, err = app.Test(req); err != nil || resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("refresh failed: %v %v", err, resp)
+	}
+	checkAssertion("refresh_token")
+}
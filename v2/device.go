@@ -0,0 +1,269 @@
+package goth_fiber
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/markbates/goth"
+	"golang.org/x/oauth2"
+)
+
+// deviceGrantType is the grant type of device access token requests.
+const deviceGrantType = "urn:ietf:params:oauth:grant-type:device_code"
+
+// deviceIntervalUnit is the unit of the polling interval, shortened by tests.
+var deviceIntervalUnit = time.Second
+
+// Options that affect how the device authorization grant works.
+type DeviceOptions struct {
+	// Provider fetches the user once a token has been obtained.
+	Provider goth.Provider
+
+	// DeviceAuthURL and TokenURL are the device authorization and token endpoints.
+	DeviceAuthURL string
+	TokenURL      string
+
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+
+	// HTTPClient used to talk to the authorization server.
+	//
+	// Defaults to http.DefaultClient.
+	HTTPClient *http.Client
+}
+
+// DeviceAuthorization is the response of the device authorization endpoint.
+// UserCode and VerificationURI are to be shown to the user.
+type DeviceAuthorization struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete,omitempty"`
+	ExpiresIn               int64  `json:"expires_in"`
+	Interval                int64  `json:"interval,omitempty"`
+}
+
+/*
+DeviceLogin logs a user in with the OAuth 2.0 Device Authorization Grant
+(RFC 8628), for devices that cannot open a browser. prompt is called with the
+code and URL to show to the user, then the token endpoint is polled until the
+user approves or denies the request, and the user is fetched with the provider.
+
+A denied or expired request is reported as a *ProviderError with the code
+"access_denied" or "expired_token".
+*/
+func DeviceLogin(ctx context.Context, opts DeviceOptions, prompt func(*DeviceAuthorization)) (goth.User, *oauth2.Token, error) {
+	if opts.Provider == nil {
+		return goth.User{}, nil, errors.New("goth_fiber: DeviceOptions.Provider is required")
+	}
+
+	da, err := RequestDeviceAuthorization(ctx, opts)
+	if err != nil {
+		return goth.User{}, nil, err
+	}
+
+	prompt(da)
+
+	token, err := PollDeviceToken(ctx, opts, da)
+	if err != nil {
+		return goth.User{}, nil, err
+	}
+
+	user, err := fetchUserWithToken(opts.Provider, token)
+	if err != nil {
+		return goth.User{}, nil, err
+	}
+
+	return user, token, nil
+}
+
+// RequestDeviceAuthorization requests a device and user code from the device authorization endpoint.
+func RequestDeviceAuthorization(ctx context.Context, opts DeviceOptions) (*DeviceAuthorization, error) {
+	form := url.Values{"client_id": {opts.ClientID}}
+	if len(opts.Scopes) > 0 {
+		form.Set("scope", strings.Join(opts.Scopes, " "))
+	}
+
+	var res struct {
+		DeviceAuthorization
+		// some providers, Google among them, use the pre-standard name
+		VerificationURL string `json:"verification_url"`
+	}
+	if err := postForm(ctx, opts.HTTPClient, opts.DeviceAuthURL, form, opts.ClientID, opts.ClientSecret, &res); err != nil {
+		return nil, err
+	}
+
+	da := res.DeviceAuthorization
+	if da.VerificationURI == "" {
+		da.VerificationURI = res.VerificationURL
+	}
+
+	if da.DeviceCode == "" {
+		return nil, errors.New("goth_fiber: device authorization response without device_code")
+	}
+
+	return &da, nil
+}
+
+// PollDeviceToken polls the token endpoint until the device code is approved,
+// denied or expires, waiting the interval asked for by the server and backing
+// off by 5 seconds on "slow_down".
+func PollDeviceToken(ctx context.Context, opts DeviceOptions, da *DeviceAuthorization) (*oauth2.Token, error) {
+	if da.ExpiresIn > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(da.ExpiresIn)*time.Second)
+		defer cancel()
+	}
+
+	interval := da.Interval
+	if interval == 0 {
+		interval = 5
+	}
+
+	form := url.Values{
+		"grant_type":  {deviceGrantType},
+		"device_code": {da.DeviceCode},
+		"client_id":   {opts.ClientID},
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return nil, &ProviderError{Code: "expired_token"}
+			}
+			return nil, ctx.Err()
+		case <-time.After(time.Duration(interval) * deviceIntervalUnit):
+		}
+
+		var res tokenResponse
+		err := postForm(ctx, opts.HTTPClient, opts.TokenURL, form, opts.ClientID, opts.ClientSecret, &res)
+
+		var providerErr *ProviderError
+		switch {
+		case err == nil:
+			return res.token(), nil
+		case !errors.As(err, &providerErr):
+			return nil, err
+		case providerErr.Code == "authorization_pending":
+		case providerErr.Code == "slow_down":
+			interval += 5
+		default:
+			return nil, err
+		}
+	}
+}
+
+// tokenResponse is the successful response of a token endpoint.
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int64  `json:"expires_in"`
+	IDToken      string `json:"id_token"`
+	Scope        string `json:"scope"`
+}
+
+func (r tokenResponse) token() *oauth2.Token {
+	token := &oauth2.Token{
+		AccessToken:  r.AccessToken,
+		TokenType:    r.TokenType,
+		RefreshToken: r.RefreshToken,
+	}
+	if r.ExpiresIn > 0 {
+		token.Expiry = time.Now().Add(time.Duration(r.ExpiresIn) * time.Second)
+	}
+
+	extra := map[string]interface{}{}
+	if r.IDToken != "" {
+		extra["id_token"] = r.IDToken
+	}
+	if r.Scope != "" {
+		extra["scope"] = r.Scope
+	}
+
+	return token.WithExtra(extra)
+}
+
+// postForm posts form to an OAuth endpoint and decodes the JSON response into
+// out. OAuth error responses are returned as a *ProviderError.
+func postForm(ctx context.Context, client *http.Client, endpoint string, form url.Values, clientID, clientSecret string, out interface{}) error {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if clientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(clientID), url.QueryEscape(clientSecret))
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var oauthErr struct {
+			Error            string `json:"error"`
+			ErrorDescription string `json:"error_description"`
+			ErrorURI         string `json:"error_uri"`
+		}
+		if json.NewDecoder(resp.Body).Decode(&oauthErr) == nil && oauthErr.Error != "" {
+			return &ProviderError{Code: oauthErr.Error, Description: oauthErr.ErrorDescription, URI: oauthErr.ErrorURI}
+		}
+		return fmt.Errorf("goth_fiber: %s returned %s", endpoint, resp.Status)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+/*
+fetchUserWithToken fetches the user for a token obtained outside of the goth
+session flow. The token is handed to the provider as a session with the field
+names shared by goth's OAuth2 providers.
+*/
+func fetchUserWithToken(provider goth.Provider, token *oauth2.Token) (goth.User, error) {
+	idToken, _ := token.Extra("id_token").(string)
+
+	b, err := json.Marshal(map[string]interface{}{
+		"AccessToken":  token.AccessToken,
+		"RefreshToken": token.RefreshToken,
+		"ExpiresAt":    token.Expiry,
+		"IDToken":      idToken,
+	})
+	if err != nil {
+		return goth.User{}, err
+	}
+
+	sess, err := provider.UnmarshalSession(string(b))
+	if err != nil {
+		return goth.User{}, err
+	}
+
+	user, err := provider.FetchUser(sess)
+	if err != nil {
+		return goth.User{}, err
+	}
+
+	if user.AccessToken == "" {
+		user.AccessToken = token.AccessToken
+		user.RefreshToken = token.RefreshToken
+		user.ExpiresAt = token.Expiry
+		user.IDToken = idToken
+	}
+
+	return user, nil
+}
@@ -0,0 +1,67 @@
+package goth_fiber
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func Test_DeviceLogin(t *testing.T) {
+	original := deviceIntervalUnit
+	deviceIntervalUnit = time.Millisecond
+	defer func() {
+		deviceIntervalUnit = original
+	}()
+
+	server := newFakeAuthServer(t)
+	opts := DeviceOptions{
+		Provider:      newTestOAuth2Provider(server, "device", "", nil),
+		DeviceAuthURL: server.URL + "/device",
+		TokenURL:      server.URL + "/token",
+		ClientID:      "client",
+		Scopes:        []string{"profile"},
+	}
+
+	var prompted *DeviceAuthorization
+	user, token, err := DeviceLogin(context.Background(), opts, func(da *DeviceAuthorization) {
+		prompted = da
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if prompted == nil || prompted.UserCode != "WDJB-MJHT" || prompted.VerificationURI == "" {
+		t.Errorf("unexpected device authorization %+v", prompted)
+	}
+	if user.UserID != "42" || token.AccessToken == "" {
+		t.Errorf("unexpected login result %+v %+v", user, token)
+	}
+	if server.devicePolls != 3 {
+		t.Errorf("expected polling through authorization_pending and slow_down, got %d polls", server.devicePolls)
+	}
+}
+
+func Test_DeviceLogin_Denied(t *testing.T) {
+	original := deviceIntervalUnit
+	deviceIntervalUnit = time.Millisecond
+	defer func() {
+		deviceIntervalUnit = original
+	}()
+
+	server := newFakeAuthServer(t)
+	opts := DeviceOptions{
+		Provider:      newTestOAuth2Provider(server, "device", "", nil),
+		DeviceAuthURL: server.URL + "/device",
+		TokenURL:      server.URL + "/token",
+		ClientID:      "client",
+		Scopes:        []string{"denied"},
+	}
+
+	_, _, err := DeviceLogin(context.Background(), opts, func(*DeviceAuthorization) {})
+
+	var providerErr *ProviderError
+	if !errors.As(err, &providerErr) || providerErr.Code != "access_denied" {
+		t.Errorf("expected access_denied, got %v", err)
+	}
+}
@@ -0,0 +1,208 @@
+package goth_fiber
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+	"golang.org/x/oauth2"
+)
+
+// dpopSessionKey prefixes the session key holding the DPoP key of a provider.
+const dpopSessionKey = "_goth_dpop_"
+
+var (
+	// dpopKeys maps the authorization codes and refresh tokens being
+	// exchanged, and the access tokens being used to fetch the user, to their
+	// DPoP key, for exchangeTransport to sign proofs with.
+	dpopKeys sync.Map
+
+	// dpopNonces holds the last DPoP nonce sent by each origin.
+	dpopNonces sync.Map
+)
+
+// newDPoPKey generates the DPoP key of the session for a provider.
+func newDPoPKey(ctx fiber.Ctx, providerName string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	return StoreInSession(dpopSessionKey+providerName, base64.StdEncoding.EncodeToString(der), ctx)
+}
+
+// sessionDPoPKey returns the DPoP key of the session for a provider, or nil
+// when its tokens are not DPoP-bound.
+func sessionDPoPKey(ctx fiber.Ctx, providerName string) *ecdsa.PrivateKey {
+	value, err := GetFromSession(dpopSessionKey+providerName, ctx)
+	if err != nil {
+		return nil
+	}
+
+	der, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return nil
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil
+	}
+
+	ecKey, _ := key.(*ecdsa.PrivateKey)
+	return ecKey
+}
+
+// tokenRequestDPoPKey returns the DPoP key registered for the code or refresh token of a token request form.
+func tokenRequestDPoPKey(form url.Values) *ecdsa.PrivateKey {
+	key, ok := dpopKeys.Load(tokenRequestID(form))
+	if !ok {
+		return nil
+	}
+	return key.(*ecdsa.PrivateKey)
+}
+
+// requestDPoPKey returns the access token of a request authorized with a
+// token registered in dpopKeys, along with its DPoP key.
+func requestDPoPKey(req *http.Request) (string, *ecdsa.PrivateKey) {
+	scheme, accessToken, ok := strings.Cut(req.Header.Get("Authorization"), " ")
+	if !ok || (!strings.EqualFold(scheme, "Bearer") && !strings.EqualFold(scheme, "DPoP")) {
+		return "", nil
+	}
+
+	key, ok := dpopKeys.Load(accessToken)
+	if !ok {
+		return "", nil
+	}
+	return accessToken, key.(*ecdsa.PrivateKey)
+}
+
+// dpopProof returns a DPoP proof for a request, bound to accessToken when it is set.
+func dpopProof(key *ecdsa.PrivateKey, method, rawURL, accessToken, nonce string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+
+	jwk, err := publicJWK(&key.PublicKey)
+	if err != nil {
+		return "", err
+	}
+
+	b, err := json.Marshal(jwk)
+	if err != nil {
+		return "", err
+	}
+
+	jti, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+
+	claims := map[string]interface{}{
+		"jti": jti,
+		"htm": method,
+		"htu": endpointURL(u),
+		"iat": time.Now().Unix(),
+	}
+	if accessToken != "" {
+		sum := sha256.Sum256([]byte(accessToken))
+		claims["ath"] = base64.RawURLEncoding.EncodeToString(sum[:])
+	}
+	if nonce != "" {
+		claims["nonce"] = nonce
+	}
+
+	return signJWT(jwtHeader{Alg: "ES256", Typ: "dpop+jwt", JWK: b}, claims, key)
+}
+
+/*
+sendWithDPoP sends req with a DPoP proof signed with key. When the server
+answers with a DPoP-Nonce challenge, the request is sent again once with a
+proof carrying the nonce.
+*/
+func sendWithDPoP(base http.RoundTripper, req *http.Request, key *ecdsa.PrivateKey, accessToken string) (*http.Response, error) {
+	origin := req.URL.Scheme + "://" + req.URL.Host
+
+	nonce := ""
+	if n, ok := dpopNonces.Load(origin); ok {
+		nonce = n.(string)
+	}
+
+	for attempt := 0; ; attempt++ {
+		proof, err := dpopProof(key, req.Method, req.URL.String(), accessToken, nonce)
+		if err != nil {
+			return nil, err
+		}
+
+		attemptReq := req.Clone(req.Context())
+		attemptReq.Header.Set("DPoP", proof)
+		if attempt > 0 && req.GetBody != nil {
+			if attemptReq.Body, err = req.GetBody(); err != nil {
+				return nil, err
+			}
+		}
+
+		resp, err := base.RoundTrip(attemptReq)
+		if err != nil {
+			return nil, err
+		}
+
+		newNonce := resp.Header.Get("DPoP-Nonce")
+		if newNonce == "" {
+			return resp, nil
+		}
+		dpopNonces.Store(origin, newNonce)
+
+		challenged := resp.StatusCode == http.StatusBadRequest || resp.StatusCode == http.StatusUnauthorized
+		if !challenged || newNonce == nonce || attempt > 0 || (req.Body != nil && req.GetBody == nil) {
+			return resp, nil
+		}
+
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		nonce = newNonce
+	}
+}
+
+// dpopTransport authenticates requests with DPoP-bound access tokens.
+type dpopTransport struct {
+	base   http.RoundTripper
+	source oauth2.TokenSource
+	key    *ecdsa.PrivateKey
+}
+
+func (t *dpopTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	token, err := t.source.Token()
+	if err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, err
+	}
+
+	clone := req.Clone(req.Context())
+	clone.Header.Set("Authorization", "DPoP "+token.AccessToken)
+	return sendWithDPoP(base, clone, t.key, token.AccessToken)
+}
@@ -0,0 +1,152 @@
+package goth_fiber
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/markbates/goth"
+)
+
+// checkDPoPProof verifies a DPoP proof against its embedded key and returns that key.
+func checkDPoPProof(proof, method, rawURL, accessToken string) (string, map[string]interface{}, error) {
+	token, err := parseJWT(proof)
+	if err != nil {
+		return "", nil, err
+	}
+
+	var jwk jsonWebKey
+	if err := json.Unmarshal(token.header.JWK, &jwk); err != nil {
+		return "", nil, err
+	}
+	key, err := jwk.publicKey()
+	if err != nil {
+		return "", nil, err
+	}
+	if err := token.verify(key); err != nil {
+		return "", nil, err
+	}
+
+	if token.header.Typ != "dpop+jwt" || stringClaim(token.claims, "htm") != method || stringClaim(token.claims, "htu") != rawURL || stringClaim(token.claims, "jti") == "" {
+		return "", nil, ErrInvalidToken
+	}
+
+	if accessToken != "" {
+		sum := sha256.Sum256([]byte(accessToken))
+		if stringClaim(token.claims, "ath") != base64.RawURLEncoding.EncodeToString(sum[:]) {
+			return "", nil, ErrInvalidToken
+		}
+	}
+
+	return string(token.header.JWK), token.claims, nil
+}
+
+func Test_DPoP(t *testing.T) {
+	server := newFakeAuthServer(t)
+
+	var resourceKey string
+	resource := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		accessToken := strings.TrimPrefix(r.Header.Get("Authorization"), "DPoP ")
+		key, claims, err := checkDPoPProof(r.Header.Get("DPoP"), r.Method, "http://"+r.Host+r.URL.Path, accessToken)
+		if err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if claims["nonce"] != "resource-nonce" {
+			w.Header().Set("DPoP-Nonce", "resource-nonce")
+			w.Header().Set("WWW-Authenticate", `DPoP error="use_dpop_nonce"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		resourceKey = key
+		_, _ = io.WriteString(w, "hello "+accessToken)
+	}))
+	defer resource.Close()
+
+	goth.ClearProviders()
+	goth.UseProviders(newTestOAuth2Provider(server, "dpop", "http://localhost/callback/dpop", ExchangeClient(nil)))
+	SetProviderOptions("dpop", ProviderOptions{DPoP: true})
+	defer SetProviderOptions("dpop", ProviderOptions{})
+
+	app := fiber.New()
+	app.Get("/auth/:provider", BeginAuthHandler)
+	app.Get("/callback/:provider", func(c fiber.Ctx) error {
+		user, err := CompleteUserAuth(c, CompleteUserAuthOptions{ShouldLogout: false})
+		if err != nil {
+			return err
+		}
+
+		// expire the tokens so that calling the resource refreshes them
+		user.ExpiresAt = time.Now().Add(-time.Minute)
+		return StoreUserInSession(user, c)
+	})
+	app.Get("/resource", func(c fiber.Ctx) error {
+		client, err := HTTPClient(c)
+		if err != nil {
+			return err
+		}
+
+		resp, err := client.Get(resource.URL + "/data?x=1")
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		body, _ := io.ReadAll(resp.Body)
+		return c.Status(resp.StatusCode).Send(body)
+	})
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/auth/dpop", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookies := resp.Cookies()
+
+	authURL := resp.Header.Get("Location")
+	location, _ := url.Parse(authURL)
+	callback := "/callback/dpop?" + url.Values{
+		"code":  {server.issueCode(authURL)},
+		"state": {location.Query().Get("state")},
+	}.Encode()
+
+	for _, path := range []string{callback, "/resource"} {
+		req := httptest.NewRequest("GET", path, nil)
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		if resp, err = app.Test(req); err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != fiber.StatusOK {
+			body, _ := io.ReadAll(resp.Body)
+			t.Fatalf("%s: unexpected status %d: %s", path, resp.StatusCode, body)
+		}
+	}
+
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != "hello at-rt" {
+		t.Errorf("expected the refreshed token to reach the resource, got %q", body)
+	}
+
+	if len(server.dpopProofs) != 2 {
+		t.Fatalf("expected DPoP proofs for the code exchange and the refresh, got %d", len(server.dpopProofs))
+	}
+	for _, proof := range server.dpopProofs {
+		key, _, err := checkDPoPProof(proof, "POST", server.URL+"/token", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if key != resourceKey {
+			t.Error("expected every proof of the session to use the same key")
+		}
+	}
+}
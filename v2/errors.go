@@ -0,0 +1,187 @@
+package goth_fiber
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/gofiber/fiber/v3"
+)
+
+var (
+	// ErrStateMismatch is returned by CompleteUserAuth when the state of the
+	// callback does not match the one sent to the provider.
+	ErrStateMismatch = errors.New("state token mismatch")
+
+	// ErrNoProvider is returned when no provider name can be found for the request.
+	ErrNoProvider = errors.New("you must select a provider")
+
+	// ErrUnknownProvider is returned when the requested provider is not registered.
+	ErrUnknownProvider = errors.New("unknown provider")
+
+	// ErrSessionNotFound is returned when a value is missing from the session.
+	ErrSessionNotFound = errors.New("could not find a matching session for this request")
+)
+
+// ProviderError is returned by CompleteUserAuth when the provider redirects
+// back with an error, e.g. because the user denied access.
+type ProviderError struct {
+	Code        string
+	Description string
+	URI         string
+}
+
+func (e *ProviderError) Error() string {
+	if e.Description != "" {
+		return fmt.Sprintf("provider returned %s: %s", e.Code, e.Description)
+	}
+	return "provider returned " + e.Code
+}
+
+// ErrorCode returns a short, stable code for errors returned by this package,
+// suitable for clients to act upon. Unknown errors are reported as "server_error".
+func ErrorCode(err error) string {
+	var providerErr *ProviderError
+	switch {
+	case err == nil:
+		return ""
+	case errors.As(err, &providerErr):
+		return providerErr.Code
+	case errors.Is(err, ErrNotAuthenticated):
+		// checked first, it wraps the reason there is no user
+		return "unauthenticated"
+	case errors.Is(err, ErrStateMismatch):
+		return "state_mismatch"
+	case errors.Is(err, ErrNoProvider):
+		return "no_provider"
+	case errors.Is(err, ErrUnknownProvider):
+		return "unknown_provider"
+	case errors.Is(err, ErrSessionNotFound):
+		return "session_not_found"
+	case errors.Is(err, ErrSessionNil):
+		return "session_unavailable"
+	case errors.Is(err, ErrTenantMismatch):
+		return "tenant_mismatch"
+	case errors.Is(err, ErrMissingToken), errors.Is(err, ErrInvalidToken):
+		return "invalid_token"
+	case errors.Is(err, ErrInvalidRefreshToken):
+		return "invalid_grant"
+	case errors.Is(err, ErrTokenNotRefreshable):
+		return "token_expired"
+	case errors.Is(err, ErrPushedAuthorization):
+		return "par_failed"
+	case errors.Is(err, ErrNoHomeRealm):
+		return "no_home_realm"
+	case errors.Is(err, ErrAuthParamNotHonored):
+		return "auth_param_not_honored"
+	case errors.Is(err, ErrScopeNotAllowed):
+		return "invalid_scope"
+	case errors.Is(err, ErrInsufficientScope):
+		return "insufficient_scope"
+	case errors.Is(err, ErrStaleAuth):
+		return "login_required"
+	case errors.Is(err, ErrStepUpUserMismatch):
+		return "user_mismatch"
+	case errors.Is(err, ErrAccessDenied):
+		return "access_denied"
+	case errors.Is(err, ErrForbidden):
+		return "forbidden"
+	case errors.Is(err, ErrAccountNotFound):
+		return "account_not_found"
+	case errors.Is(err, ErrIdentityInUse):
+		return "identity_in_use"
+	case errors.Is(err, ErrLastIdentity):
+		return "last_identity"
+	case errors.Is(err, ErrOnboardingRequired):
+		return "onboarding_required"
+	}
+
+	return "server_error"
+}
+
+// ErrorStatus returns the HTTP status code matching the ErrorCode of err.
+func ErrorStatus(err error) int {
+	var providerErr *ProviderError
+	if errors.As(err, &providerErr) {
+		if providerErr.Code == "access_denied" {
+			return fiber.StatusForbidden
+		}
+		return fiber.StatusBadRequest
+	}
+
+	switch ErrorCode(err) {
+	case "state_mismatch", "no_provider", "unknown_provider", "session_not_found", "invalid_grant", "no_home_realm":
+		return fiber.StatusBadRequest
+	case "invalid_token", "token_expired", "login_required", "unauthenticated", "tenant_mismatch":
+		return fiber.StatusUnauthorized
+	case "auth_param_not_honored", "insufficient_scope", "forbidden", "access_denied", "onboarding_required", "user_mismatch":
+		return fiber.StatusForbidden
+	case "invalid_scope":
+		return fiber.StatusBadRequest
+	case "par_failed":
+		return fiber.StatusBadGateway
+	case "account_not_found":
+		return fiber.StatusNotFound
+	case "identity_in_use", "last_identity":
+		return fiber.StatusConflict
+	}
+
+	return fiber.StatusInternalServerError
+}
+
+// describedErrors are the errors whose message errorDescription shows to
+// clients, in the order of ErrorCode. The messages of other errors may hold
+// internal details.
+var describedErrors = []error{
+	ErrNotAuthenticated,
+	ErrStateMismatch,
+	ErrNoProvider,
+	ErrUnknownProvider,
+	ErrSessionNotFound,
+	ErrTenantMismatch,
+	ErrMissingToken,
+	ErrInvalidToken,
+	ErrInvalidRefreshToken,
+	ErrTokenNotRefreshable,
+	ErrPushedAuthorization,
+	ErrNoHomeRealm,
+	ErrAuthParamNotHonored,
+	ErrScopeNotAllowed,
+	ErrInsufficientScope,
+	ErrStaleAuth,
+	ErrStepUpUserMismatch,
+	ErrAccessDenied,
+	ErrForbidden,
+	ErrAccountNotFound,
+	ErrIdentityInUse,
+	ErrLastIdentity,
+	ErrOnboardingRequired,
+}
+
+// JSONErrorHandler responds with a JSON error body for err, using ErrorCode
+// and ErrorStatus. It can be used wherever an options struct of this package
+// takes an error handler. The error_description is errorDescription of err.
+func JSONErrorHandler(ctx fiber.Ctx, err error) error {
+	return ctx.Status(ErrorStatus(err)).JSON(fiber.Map{
+		"error":             ErrorCode(err),
+		"error_description": errorDescription(err),
+	})
+}
+
+// errorDescription describes err for clients: the description sent by the
+// provider, or the message of the error of this package err wraps, never the
+// message of err itself.
+func errorDescription(err error) string {
+	var providerErr *ProviderError
+	if errors.As(err, &providerErr) {
+		return providerErr.Description
+	}
+
+	for _, described := range describedErrors {
+		if errors.Is(err, described) {
+			return strings.TrimPrefix(described.Error(), "goth_fiber: ")
+		}
+	}
+
+	return "internal error"
+}
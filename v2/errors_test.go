@@ -0,0 +1,75 @@
+package goth_fiber
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v3"
+)
+
+func Test_ErrorCodeAndStatus(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		err    error
+		code   string
+		status int
+	}{
+		{ErrStateMismatch, "state_mismatch", fiber.StatusBadRequest},
+		{fmt.Errorf("%w: google", ErrUnknownProvider), "unknown_provider", fiber.StatusBadRequest},
+		{&ProviderError{Code: "access_denied"}, "access_denied", fiber.StatusForbidden},
+		{&ProviderError{Code: "temporarily_unavailable"}, "temporarily_unavailable", fiber.StatusBadRequest},
+		{fmt.Errorf("%w: bad signature", ErrInvalidToken), "invalid_token", fiber.StatusUnauthorized},
+		{errors.New("boom"), "server_error", fiber.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		if code := ErrorCode(tt.err); code != tt.code {
+			t.Errorf("ErrorCode(%v) = %s, want %s", tt.err, code, tt.code)
+		}
+		if status := ErrorStatus(tt.err); status != tt.status {
+			t.Errorf("ErrorStatus(%v) = %d, want %d", tt.err, status, tt.status)
+		}
+	}
+}
+
+func Test_JSONErrorHandler_Description(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		err         error
+		description string
+	}{
+		{&ProviderError{Code: "access_denied", Description: "The user denied access"}, "The user denied access"},
+		{fmt.Errorf("%w: kid key-1 not found at https://internal.example.com/jwks", ErrInvalidToken), "invalid token"},
+		{fmt.Errorf("%w: %w", ErrNotAuthenticated, errors.New("dial tcp 10.0.0.1:6379: connection refused")), "not authenticated"},
+		{&AccessDeniedError{Reason: "user is not allowed"}, "access denied"},
+		{errors.New("pq: relation \"accounts\" does not exist"), "internal error"},
+	}
+
+	app := fiber.New()
+	for i, tt := range tests {
+		err := tt.err
+		app.Get(fmt.Sprintf("/%d", i), func(c fiber.Ctx) error {
+			return JSONErrorHandler(c, err)
+		})
+	}
+
+	for i, tt := range tests {
+		resp, err := app.Test(httptest.NewRequest("GET", fmt.Sprintf("/%d", i), nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var body map[string]string
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		if body["error_description"] != tt.description {
+			t.Errorf("%v: expected description %q, got %q", tt.err, tt.description, body["error_description"])
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package goth_fiber
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/markbates/goth"
+)
+
+// Headers set by ForwardAuthHandler on authenticated requests.
+const (
+	HeaderAuthRequestUser   = "X-Auth-Request-User"
+	HeaderAuthRequestEmail  = "X-Auth-Request-Email"
+	HeaderAuthRequestGroups = "X-Auth-Request-Groups"
+)
+
+// Options that affect how ForwardAuthHandler works.
+type ForwardAuthOptions struct {
+	// LoginURL is where unauthenticated users are redirected to, typically a
+	// route served by BeginAuthHandler. The original URL is passed along as
+	// ReturnToParam.
+	//
+	// Defaults to empty, in which case 401 Unauthorized is returned.
+	LoginURL string
+
+	// Groups returns the groups reported in X-Auth-Request-Groups.
+	//
+	// Defaults to no groups.
+	Groups func(user goth.User) []string
+}
+
+/*
+ForwardAuthHandler returns a handler for nginx auth_request and Traefik
+ForwardAuth. It answers 202 Accepted with the X-Auth-Request-* identity headers
+when the session holds a user stored by CompleteUserAuth, and 401 Unauthorized
+or a redirect to the login URL otherwise.
+
+The URL to return to after login is built from the X-Forwarded-Proto,
+X-Forwarded-Host and X-Forwarded-Uri headers; remember to list the proxied
+hosts in AllowedReturnToHosts. Please note that any options provided in
+addition to the first will be ignored.
+*/
+func ForwardAuthHandler(options ...ForwardAuthOptions) fiber.Handler {
+	var opts ForwardAuthOptions
+	if len(options) > 0 {
+		opts = options[0]
+	}
+
+	return func(ctx fiber.Ctx) error {
+		user, err := GetUserFromSession(ctx)
+		if err != nil {
+			if opts.LoginURL == "" {
+				return ctx.SendStatus(fiber.StatusUnauthorized)
+			}
+
+			return ctx.Redirect().To(loginURLWithReturnTo(opts.LoginURL, forwardedURL(ctx)))
+		}
+
+		name := user.NickName
+		if name == "" {
+			name = user.UserID
+		}
+
+		ctx.Set(HeaderAuthRequestUser, name)
+		ctx.Set(HeaderAuthRequestEmail, user.Email)
+		if opts.Groups != nil {
+			if groups := opts.Groups(user); len(groups) > 0 {
+				ctx.Set(HeaderAuthRequestGroups, strings.Join(groups, ","))
+			}
+		}
+
+		return ctx.SendStatus(fiber.StatusAccepted)
+	}
+}
+
+// forwardedURL rebuilds the URL originally requested from the proxy's X-Forwarded-* headers.
+func forwardedURL(ctx fiber.Ctx) string {
+	uri := ctx.Get("X-Forwarded-Uri", "/")
+	host := ctx.Get(fiber.HeaderXForwardedHost)
+	if host == "" {
+		return uri
+	}
+
+	scheme := ctx.Get(fiber.HeaderXForwardedProto, ctx.Scheme())
+	return scheme + "://" + host + uri
+}
+
+func loginURLWithReturnTo(loginURL, returnTo string) string {
+	sep := "?"
+	if strings.Contains(loginURL, "?") {
+		sep = "&"
+	}
+
+	return loginURL + sep + ReturnToParam + "=" + url.QueryEscape(returnTo)
+}
@@ -0,0 +1,84 @@
+package goth_fiber
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/markbates/goth"
+)
+
+func Test_ForwardAuthHandler(t *testing.T) {
+	t.Parallel()
+
+	app := fiber.New()
+	app.Get("/login", func(c fiber.Ctx) error {
+		return StoreUserInSession(goth.User{Provider: "faux", UserID: "42", Email: "jane@example.com"}, c)
+	})
+	app.Get("/auth", ForwardAuthHandler(ForwardAuthOptions{
+		Groups: func(user goth.User) []string {
+			return []string{"admins", "staff"}
+		},
+	}))
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/auth", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != fiber.StatusUnauthorized {
+		t.Fatalf("expected status %d, got %d", fiber.StatusUnauthorized, resp.StatusCode)
+	}
+
+	resp, err = app.Test(httptest.NewRequest("GET", "/login", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest("GET", "/auth", nil)
+	for _, cookie := range resp.Cookies() {
+		req.AddCookie(cookie)
+	}
+	resp, err = app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.StatusCode != fiber.StatusAccepted {
+		t.Fatalf("expected status %d, got %d", fiber.StatusAccepted, resp.StatusCode)
+	}
+	if got := resp.Header.Get(HeaderAuthRequestUser); got != "42" {
+		t.Errorf("expected user header '42', got '%s'", got)
+	}
+	if got := resp.Header.Get(HeaderAuthRequestEmail); got != "jane@example.com" {
+		t.Errorf("expected email header, got '%s'", got)
+	}
+	if got := resp.Header.Get(HeaderAuthRequestGroups); got != "admins,staff" {
+		t.Errorf("expected groups header 'admins,staff', got '%s'", got)
+	}
+}
+
+func Test_ForwardAuthHandler_RedirectsToLogin(t *testing.T) {
+	t.Parallel()
+
+	app := fiber.New()
+	app.Get("/auth", ForwardAuthHandler(ForwardAuthOptions{LoginURL: "https://auth.example.com/login/google"}))
+
+	req := httptest.NewRequest("GET", "/auth", nil)
+	req.Header.Set("X-Forwarded-Proto", "https")
+	req.Header.Set("X-Forwarded-Host", "grafana.example.com")
+	req.Header.Set("X-Forwarded-Uri", "/d/abc?orgId=1")
+
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := location.Query().Get(ReturnToParam); got != "https://grafana.example.com/d/abc?orgId=1" {
+		t.Errorf("unexpected return-to '%s'", got)
+	}
+}
@@ -0,0 +1,205 @@
+package goth_fiber
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/markbates/goth"
+)
+
+const (
+	// authTimeSessionKey holds the time the user last authenticated at, in unix seconds.
+	authTimeSessionKey = "_goth_auth_time"
+
+	// acrSessionKey holds the authentication context class of the last authentication.
+	acrSessionKey = "_goth_acr"
+
+	// stepUpSessionKey holds the time RequireFreshAuth last sent the user to
+	// the provider, the identity expected back and the state of the request.
+	stepUpSessionKey = "_goth_step_up"
+
+	// stepUpTimeout is how long the user has to complete a step-up.
+	stepUpTimeout = 10 * time.Minute
+)
+
+var (
+	// ErrStaleAuth is returned by RequireFreshAuth when the user did not
+	// authenticate recently enough, or not with the required context class.
+	ErrStaleAuth = errors.New("goth_fiber: recent authentication required")
+
+	// ErrStepUpUserMismatch is returned by CompleteUserAuth when the user
+	// authenticating for a step-up of RequireFreshAuth is not the session
+	// user. The session user is kept.
+	ErrStepUpUserMismatch = errors.New("goth_fiber: step-up authenticated a different user")
+)
+
+// recordAuthentication stores the auth_time and acr of a completed
+// authentication in the session, taken from the claims in the RawData of
+// user when the provider sends them.
+func recordAuthentication(ctx fiber.Ctx, user goth.User) error {
+	authTime, ok := numericClaim(user.RawData, "auth_time")
+	if !ok {
+		authTime = time.Now()
+	}
+
+	if err := StoreInSession(authTimeSessionKey, strconv.FormatInt(authTime.Unix(), 10), ctx); err != nil {
+		return err
+	}
+
+	return StoreInSession(acrSessionKey, stringClaim(user.RawData, "acr"), ctx)
+}
+
+// GetAuthTime returns the time the session user last authenticated at, as
+// recorded by CompleteUserAuth when it keeps the session.
+func GetAuthTime(ctx fiber.Ctx) (time.Time, error) {
+	value, err := GetFromSession(authTimeSessionKey, ctx)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	seconds, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return time.Unix(seconds, 0), nil
+}
+
+/*
+RequireFreshAuth returns a middleware letting requests through only when the
+session user authenticated less than maxAge ago and, when acr is set, with
+that authentication context class.
+
+Otherwise GET requests are sent to the user's provider again with
+prompt=login, max_age and acr_values, and the current URL as return-to, see
+GetReturnTo. Other requests and step-ups the provider did not honor are
+handed to AuthorizationErrorHandler with ErrStaleAuth, and requests without a
+session user with ErrNotAuthenticated. The step-up must authenticate the
+session user again within 10 minutes: CompleteUserAuth rejects other users
+completing its authorization request with ErrStepUpUserMismatch.
+*/
+func RequireFreshAuth(maxAge time.Duration, acr string) fiber.Handler {
+	return func(ctx fiber.Ctx) error {
+		user, err := GetUserFromSession(ctx)
+		if err != nil {
+			return AuthorizationErrorHandler(ctx, fmt.Errorf("%w: %w", ErrNotAuthenticated, err))
+		}
+
+		authTime, err := GetAuthTime(ctx)
+		fresh := err == nil && time.Since(authTime) <= maxAge
+		if fresh && acr != "" {
+			achieved, _ := GetFromSession(acrSessionKey, ctx)
+			fresh = achieved == acr
+		}
+
+		s, pending := loadStepUp(ctx)
+
+		if fresh {
+			if pending {
+				if err := StoreInSession(stepUpSessionKey, "", ctx); err != nil {
+					return err
+				}
+			}
+			return ctx.Next()
+		}
+
+		if ctx.Method() != fiber.MethodGet && ctx.Method() != fiber.MethodHead {
+			return AuthorizationErrorHandler(ctx, ErrStaleAuth)
+		}
+
+		// a step-up that just came back stale would loop forever
+		if pending && time.Since(s.started) < time.Minute {
+			return AuthorizationErrorHandler(ctx, ErrStaleAuth)
+		}
+
+		params := url.Values{
+			"prompt":  {"login"},
+			"max_age": {strconv.FormatInt(int64(maxAge/time.Second), 10)},
+		}
+		if acr != "" {
+			params.Set("acr_values", acr)
+		}
+
+		GetContextWithAuthParams(ctx, params)
+
+		// the step-up is for the provider of the session user, whatever the request says
+		authURL, err := getAuthURL(ctx, user.Provider)
+		if err != nil {
+			return ctx.Status(ErrorStatus(err)).SendString(err.Error())
+		}
+
+		if err := StoreInSession(returnToSessionKey, ctx.OriginalURL(), ctx); err != nil {
+			return err
+		}
+
+		state, _ := ctx.Locals(stateKey).(string)
+		values := url.Values{
+			"started":  {strconv.FormatInt(time.Now().Unix(), 10)},
+			"provider": {user.Provider},
+			"user_id":  {user.UserID},
+			"state":    {state},
+		}
+		if err := StoreInSession(stepUpSessionKey, values.Encode(), ctx); err != nil {
+			return err
+		}
+
+		return ctx.Redirect().Status(fiber.StatusTemporaryRedirect).To(authURL)
+	}
+}
+
+// stepUp is a step-up RequireFreshAuth started for the session.
+type stepUp struct {
+	// started is when the user was sent to the provider.
+	started time.Time
+
+	// user is the identity of the session user then.
+	user Identity
+
+	// state is the state of the authorization request of the step-up.
+	state string
+}
+
+// loadStepUp returns the step-up RequireFreshAuth started for the session, if
+// one is pending.
+func loadStepUp(ctx fiber.Ctx) (stepUp, bool) {
+	value, err := GetFromSession(stepUpSessionKey, ctx)
+	if err != nil || value == "" {
+		return stepUp{}, false
+	}
+
+	values, err := url.ParseQuery(value)
+	if err != nil {
+		return stepUp{}, false
+	}
+
+	seconds, err := strconv.ParseInt(values.Get("started"), 10, 64)
+	if err != nil {
+		return stepUp{}, false
+	}
+
+	return stepUp{
+		started: time.Unix(seconds, 0),
+		user:    Identity{Provider: values.Get("provider"), UserID: values.Get("user_id")},
+		state:   values.Get("state"),
+	}, true
+}
+
+// checkStepUpUser rejects a user completing a pending step-up who is not the
+// one the step-up was started for. Only the callback of the authorization
+// request of the step-up counts, and only within stepUpTimeout: logins the
+// user starts after abandoning it are not step-ups.
+func checkStepUpUser(ctx fiber.Ctx, user goth.User) error {
+	s, pending := loadStepUp(ctx)
+	if !pending || s.state != GetState(ctx) || time.Since(s.started) > stepUpTimeout {
+		return nil
+	}
+
+	if IdentityOf(user) != s.user {
+		return ErrStepUpUserMismatch
+	}
+	return nil
+}
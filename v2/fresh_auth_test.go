@@ -0,0 +1,191 @@
+package goth_fiber
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/markbates/goth"
+)
+
+func Test_RequireFreshAuth(t *testing.T) {
+	server := newFakeAuthServer(t)
+
+	goth.ClearProviders()
+	goth.UseProviders(newTestOAuth2Provider(server, "fresh", "http://localhost/callback/fresh", nil))
+
+	app := fiber.New()
+	app.Get("/auth/:provider", BeginAuthHandler)
+	app.Get("/callback/:provider", func(c fiber.Ctx) error {
+		if _, err := CompleteUserAuth(c, CompleteUserAuthOptions{ShouldLogout: false}); err != nil {
+			return err
+		}
+		return c.Redirect().To(GetReturnTo(c))
+	})
+	app.Get("/age", func(c fiber.Ctx) error {
+		// pretend the login happened a while ago
+		return StoreInSession(authTimeSessionKey, strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10), c)
+	})
+	app.Get("/billing", RequireFreshAuth(5*time.Minute, ""), func(c fiber.Ctx) error {
+		return c.SendString("billing")
+	})
+
+	var cookies []*http.Cookie
+	do := func(path string) *http.Response {
+		t.Helper()
+
+		req := httptest.NewRequest("GET", path, nil)
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(resp.Cookies()) > 0 {
+			cookies = resp.Cookies()
+		}
+		return resp
+	}
+
+	callback := func(authURL string) *http.Response {
+		location, _ := url.Parse(authURL)
+		return do("/callback/fresh?" + url.Values{
+			"code":  {server.issueCode(authURL)},
+			"state": {location.Query().Get("state")},
+		}.Encode())
+	}
+
+	if resp := do("/billing"); resp.StatusCode != fiber.StatusUnauthorized {
+		t.Fatalf("expected anonymous users to be rejected, got %d", resp.StatusCode)
+	}
+
+	callback(do("/auth/fresh").Header.Get("Location"))
+	if resp := do("/billing"); resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("expected a fresh login to pass, got %d", resp.StatusCode)
+	}
+
+	do("/age")
+	resp := do("/billing?tab=invoices")
+	if resp.StatusCode != fiber.StatusTemporaryRedirect {
+		t.Fatalf("expected a stale login to be sent to the provider, got %d", resp.StatusCode)
+	}
+
+	authURL := resp.Header.Get("Location")
+	location, _ := url.Parse(authURL)
+	if location.Query().Get("prompt") != "login" || location.Query().Get("max_age") != "300" {
+		t.Errorf("expected prompt=login and max_age, got %s", authURL)
+	}
+
+	resp = callback(authURL)
+	if got := resp.Header.Get("Location"); got != "/billing?tab=invoices" {
+		t.Errorf("expected the user to be sent back to the route, got %q", got)
+	}
+
+	resp = do("/billing?tab=invoices")
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != "billing" {
+		t.Errorf("expected the step-up to pass, got %d %s", resp.StatusCode, body)
+	}
+}
+
+func Test_RequireFreshAuth_PinnedToSessionUser(t *testing.T) {
+	server := newFakeAuthServer(t)
+	other := newFakeAuthServer(t)
+
+	goth.ClearProviders()
+	goth.UseProviders(
+		newTestOAuth2Provider(server, "fresh", "http://localhost/callback/fresh", nil),
+		newTestOAuth2Provider(other, "other", "http://localhost/callback/other", nil),
+	)
+
+	app := fiber.New()
+	app.Get("/auth/:provider", BeginAuthHandler)
+	app.Get("/callback/:provider", func(c fiber.Ctx) error {
+		if _, err := CompleteUserAuth(c, CompleteUserAuthOptions{ShouldLogout: false}); err != nil {
+			return c.Status(ErrorStatus(err)).SendString(ErrorCode(err))
+		}
+		return c.Redirect().To(GetReturnTo(c))
+	})
+	app.Get("/login", func(c fiber.Ctx) error {
+		// the session of another user, e.g. a stolen cookie
+		return StoreUserInSession(goth.User{Provider: "fresh", UserID: "7", AccessToken: "victim"}, c)
+	})
+	app.Get("/me", func(c fiber.Ctx) error {
+		user, err := GetUserFromSession(c)
+		if err != nil {
+			return err
+		}
+		return c.SendString(user.UserID)
+	})
+	app.Get("/billing", RequireFreshAuth(5*time.Minute, ""), func(c fiber.Ctx) error {
+		return c.SendString("billing")
+	})
+
+	var cookies []*http.Cookie
+	do := func(path string) *http.Response {
+		t.Helper()
+
+		req := httptest.NewRequest("GET", path, nil)
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(resp.Cookies()) > 0 {
+			cookies = resp.Cookies()
+		}
+		return resp
+	}
+
+	do("/login")
+	resp := do("/billing?provider=other")
+	authURL := resp.Header.Get("Location")
+	if !strings.HasPrefix(authURL, server.URL) {
+		t.Fatalf("expected the step-up to use the provider of the session user, got %d %s", resp.StatusCode, authURL)
+	}
+
+	// the fake provider authenticates user 42
+	location, _ := url.Parse(authURL)
+	resp = do("/callback/fresh?" + url.Values{
+		"code":  {server.issueCode(authURL)},
+		"state": {location.Query().Get("state")},
+	}.Encode())
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != fiber.StatusForbidden || string(body) != "user_mismatch" {
+		t.Errorf("expected the step-up of another user to be rejected, got %d %s", resp.StatusCode, body)
+	}
+
+	body, _ = io.ReadAll(do("/me").Body)
+	if string(body) != "7" {
+		t.Errorf("expected the session user to be kept, got %s", body)
+	}
+	if resp := do("/billing"); resp.StatusCode != fiber.StatusUnauthorized {
+		t.Errorf("expected the rejected step-up not to count, got %d", resp.StatusCode)
+	}
+
+	// a login the user starts after giving up on the step-up is not one
+	authURL = do("/auth/fresh").Header.Get("Location")
+	location, _ = url.Parse(authURL)
+	resp = do("/callback/fresh?" + url.Values{
+		"code":  {server.issueCode(authURL)},
+		"state": {location.Query().Get("state")},
+	}.Encode())
+	if resp.StatusCode != fiber.StatusSeeOther && resp.StatusCode != fiber.StatusFound {
+		body, _ := io.ReadAll(resp.Body)
+		t.Fatalf("expected the login of another account to succeed, got %d %s", resp.StatusCode, body)
+	}
+
+	body, _ = io.ReadAll(do("/me").Body)
+	if string(body) != "42" {
+		t.Errorf("expected the new login to replace the session user, got %s", body)
+	}
+}
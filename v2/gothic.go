@@ -6,6 +6,7 @@ import (
 	"crypto/rand"
 	"encoding/base64"
 	"errors"
+	"fmt"
 	"io"
 	"net/url"
 	"strings"
@@ -17,8 +18,31 @@ import (
 	"github.com/markbates/goth/gothic"
 )
 
-// ProviderParamKey can be used as a key in context when passing in a provider
-const ProviderParamKey key = iota
+const (
+	// ProviderParamKey can be used as a key in context when passing in a provider
+	ProviderParamKey key = iota
+
+	// returnToKey holds the return-to URL of a completed authentication in Locals
+	returnToKey
+
+	// userKey holds the user authenticated for the current request in Locals
+	userKey
+
+	// pkceKey marks requests for which GetAuthURL uses PKCE in Locals
+	pkceKey
+
+	// providerInstanceKey holds a provider that takes precedence over goth's registry in Locals
+	providerInstanceKey
+
+	// authParamsKey holds the extra authorization request parameters of GetAuthURL in Locals
+	authParamsKey
+
+	// scopesKey holds the extra scopes requested by GetAuthURL in Locals
+	scopesKey
+
+	// stateKey holds the state of the authorization request built by GetAuthURL in Locals
+	stateKey
+)
 
 // Session can/should be set by applications using gothic. The default is a cookie store.
 var (
@@ -92,7 +116,10 @@ GetAuthURL starts the authentication process with the requested provided.
 It will return a URL that should be used to send users to.
 
 It expects to be able to get the name of the provider from the query parameters
-as either "provider" or ":provider".
+as either "provider" or ":provider". A URL passed in the ReturnToParam query
+parameter is kept in the session, see GetReturnTo. Depending on the
+ProviderOptions of the provider, extra authorization parameters and scopes,
+PKCE, DPoP and pushed authorization requests are used.
 
 I would recommend using the BeginAuthHandler instead of doing all of these steps
 yourself, but that's entirely up to you.
@@ -107,26 +134,86 @@ func GetAuthURL(ctx fiber.Ctx) (string, error) {
 		return "", err
 	}
 
-	provider, err := goth.GetProvider(providerName)
+	return getAuthURL(ctx, providerName)
+}
+
+// getAuthURL starts the authentication process with the named provider, see
+// GetAuthURL.
+func getAuthURL(ctx fiber.Ctx, providerName string) (string, error) {
+	if SessionManager == nil {
+		return "", ErrSessionNil
+	}
+
+	provider, err := getProvider(ctx, providerName)
 	if err != nil {
 		return "", err
 	}
 
-	sess, err := provider.BeginAuth(SetState(ctx))
+	state := SetState(ctx)
+	sess, err := provider.BeginAuth(state)
 	if err != nil {
 		return "", err
 	}
+	ctx.Locals(stateKey, state)
 
 	url, err := sess.GetAuthURL()
 	if err != nil {
 		return "", err
 	}
 
+	opts := requestProviderOptions(ctx, providerName)
+	params := buildAuthParams(ctx, opts)
+	if len(params) > 0 {
+		url, err = addQueryParams(url, params)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	// also without parameters, so that those of an earlier flow are not
+	// taken for this one's
+	if err := storeAuthParams(ctx, providerName, params); err != nil {
+		return "", err
+	}
+
+	url, err = applyScopes(ctx, providerName, url, opts)
+	if err != nil {
+		return "", err
+	}
+
+	if opts.PKCE || usesPKCE(ctx) {
+		url, err = applyPKCE(ctx, providerName, url)
+		if err != nil {
+			return "", err
+		}
+	} else if err := StoreInSession(pkceSessionKey+providerName, "", ctx); err != nil {
+		// an earlier PKCE flow must not leave its verifier to this one
+		return "", err
+	}
+
+	if opts.DPoP {
+		if err := newDPoPKey(ctx, providerName); err != nil {
+			return "", err
+		}
+	}
+
+	if opts.PushedAuthorizationURL != "" {
+		url, err = pushAuthorizationRequest(ctx, providerName, url, opts)
+		if err != nil {
+			return "", err
+		}
+	}
+
 	err = StoreInSession(providerName, sess.Marshal(), ctx)
 	if err != nil {
 		return "", err
 	}
 
+	// an empty one clears the return-to of an earlier flow
+	if err := StoreInSession(returnToSessionKey, ctx.Query(ReturnToParam), ctx); err != nil {
+		return "", err
+	}
+
 	return url, err
 }
 
@@ -147,7 +234,8 @@ as either "provider" or ":provider".
 
 This method automatically ends the session. You can prevent this behavior by
 passing in options. Please note that any options provided in addition to the
-first will be ignored.
+first will be ignored. When the session is kept, the user is stored in it and
+can be retrieved later with GetUserFromSession.
 
 See https://github.com/markbates/goth/examples/main.go to see this in action.
 */
@@ -161,7 +249,7 @@ func CompleteUserAuth(ctx fiber.Ctx, options ...CompleteUserAuthOptions) (goth.U
 		return goth.User{}, err
 	}
 
-	provider, err := goth.GetProvider(providerName)
+	provider, err := getProvider(ctx, providerName)
 	if err != nil {
 		return goth.User{}, err
 	}
@@ -180,6 +268,31 @@ func CompleteUserAuth(ctx fiber.Ctx, options ...CompleteUserAuthOptions) (goth.U
 		defer Logout(ctx)
 	}
 
+	// remember where to send the user back to before the session goes away
+	if err := takeReturnTo(ctx); err != nil {
+		return goth.User{}, err
+	}
+
+	// and what the authorization request asked for
+	if err := loadAuthParams(ctx, providerName); err != nil {
+		return goth.User{}, err
+	}
+
+	// and the PKCE code verifier, which is good for one exchange only
+	verifier, err := takePKCEVerifier(ctx, providerName)
+	if err != nil {
+		return goth.User{}, err
+	}
+
+	// the provider may send the user back with an error instead of a code
+	if code := ctx.Query("error"); code != "" {
+		return goth.User{}, &ProviderError{
+			Code:        code,
+			Description: ctx.Query("error_description"),
+			URI:         ctx.Query("error_uri"),
+		}
+	}
+
 	sess, err := provider.UnmarshalSession(value)
 	if err != nil {
 		return goth.User{}, err
@@ -190,24 +303,118 @@ func CompleteUserAuth(ctx fiber.Ctx, options ...CompleteUserAuthOptions) (goth.U
 		return goth.User{}, err
 	}
 
+	var capture *tokenCapture
 	user, err := provider.FetchUser(sess)
-	if err == nil {
-		// user can be found with existing session data
-		return user, err
+	if err != nil {
+		// hand the PKCE code verifier to ExchangeClient for the code exchange
+		if verifier != "" {
+			code := ctx.Query("code")
+			pkceVerifiers.Store(code, verifier)
+			defer pkceVerifiers.Delete(code)
+		}
+
+		// and the DPoP key of the session
+		dpopKey := sessionDPoPKey(ctx, providerName)
+		if dpopKey != nil {
+			code := ctx.Query("code")
+			dpopKeys.Store(code, dpopKey)
+			defer dpopKeys.Delete(code)
+		}
+
+		// and the options of the provider, to authenticate the client with
+		tokenRequestOptions.Store(ctx.Query("code"), requestProviderOptions(ctx, providerName))
+		defer tokenRequestOptions.Delete(ctx.Query("code"))
+
+		// and find out which scopes are granted, and the type of the token
+		capture = &tokenCapture{}
+		tokenResponses.Store(ctx.Query("code"), capture)
+		defer tokenResponses.Delete(ctx.Query("code"))
+
+		// get new token and retry fetch
+		accessToken, err := sess.Authorize(provider, &Params{ctx: ctx})
+		if err != nil {
+			return goth.User{}, err
+		}
+
+		// a DPoP-bound token needs proofs for the user info request too
+		if dpopKey != nil && accessToken != "" {
+			dpopKeys.Store(accessToken, dpopKey)
+			defer dpopKeys.Delete(accessToken)
+		}
+
+		err = StoreInSession(providerName, sess.Marshal(), ctx)
+		if err != nil {
+			return goth.User{}, err
+		}
+
+		user, err = provider.FetchUser(sess)
+		if err != nil {
+			return user, err
+		}
 	}
 
-	// get new token and retry fetch
-	_, err = sess.Authorize(provider, &Params{ctx: ctx})
+	// report the name the provider was used under, which may be an alias
+	user.Provider = providerName
+
+	user, err = mapClaims(ctx, providerName, user)
 	if err != nil {
 		return goth.User{}, err
 	}
 
-	err = StoreInSession(providerName, sess.Marshal(), ctx)
-	if err != nil {
+	if err := admit(ctx, providerName, user); err != nil {
+		// nothing of a rejected login stays in the session
+		if !shouldLogout {
+			_ = Logout(ctx)
+		}
 		return goth.User{}, err
 	}
 
-	return provider.FetchUser(sess)
+	// keep the user around for TokenSource and friends when the session survives
+	if !shouldLogout {
+		if err := checkStepUpUser(ctx, user); err != nil {
+			return goth.User{}, err
+		}
+
+		// along with what the code exchange said about its tokens
+		if capture != nil {
+			if err := recordTokenResponse(ctx, providerName, user, capture); err != nil {
+				return goth.User{}, err
+			}
+		}
+
+		if err := StoreUserInSession(user, ctx); err != nil {
+			return goth.User{}, err
+		}
+
+		if err := recordAuthentication(ctx, user); err != nil {
+			return goth.User{}, err
+		}
+	}
+
+	return user, nil
+}
+
+// getProvider looks up a provider by name, preferring one set in Locals for
+// the request, then aliases, over the ones of Resolver or registered with goth.
+func getProvider(ctx fiber.Ctx, name string) (goth.Provider, error) {
+	if provider, ok := ctx.Locals(providerInstanceKey).(goth.Provider); ok && provider.Name() == name {
+		return provider, nil
+	}
+
+	if provider, ok := aliasedProvider(name); ok {
+		return provider, nil
+	}
+
+	if Resolver != nil {
+		return Resolver.GetProvider(ctx, name)
+	}
+
+	provider, err := goth.GetProvider(name)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownProvider, name)
+	}
+
+	return provider, nil
 }
 
 // validateState ensures that the state token param from the original
@@ -225,7 +432,7 @@ func validateState(ctx fiber.Ctx, sess goth.Session) error {
 
 	originalState := authURL.Query().Get("state")
 	if originalState != "" && (originalState != ctx.Query("state")) {
-		return errors.New("state token mismatch")
+		return ErrStateMismatch
 	}
 	return nil
 }
@@ -235,36 +442,50 @@ func Logout(ctx fiber.Ctx) error {
 	return SessionManager.delSession(ctx)
 }
 
-// GetProviderName is a function used to get the name of a provider
-// for a given request. By default, this provider is fetched from
-// the URL query string. If you provide it in a different way,
-// assign your own function to this variable that returns the provider
-// name for your request.
+/*
+GetProviderName gets the name of the provider for a given request. A name set
+by GetContextWithProvider is used first, whatever the request says. Otherwise
+the ProviderNameExtractors are tried in order, by default the "provider"
+query parameter, the ":provider" route parameter and the "provider" header.
+When none finds a name and ProviderNameSessionFallback is set, the first
+provider the session holds an authentication for is used.
+
+Names outside of AllowedProviders, when set, are rejected with an error
+wrapping ErrUnknownProvider.
+*/
 func GetProviderName(ctx fiber.Ctx) (string, error) {
-	// try to get it from the url param "provider"
-	if p := ctx.Query("provider"); p != "" {
-		return p, nil
+	// route parameters and headers point into buffers fasthttp reuses, while
+	// the name ends up in sessions and user stores, so it is copied
+	if p, _ := ctx.Locals(ProviderParamKey).(string); p != "" {
+		if err := checkAllowedProvider(p); err != nil {
+			return "", err
+		}
+		return strings.Clone(p), nil
 	}
 
-	// try to get it from the url param ":provider"
-	if p := ctx.Params("provider"); p != "" {
-		return p, nil
+	for _, extract := range ProviderNameExtractors {
+		if p := extract(ctx); p != "" {
+			if err := checkAllowedProvider(p); err != nil {
+				return "", err
+			}
+			return strings.Clone(p), nil
+		}
 	}
 
-	//  try to get it from the Fasthttp context's value of "provider" key
-	if p := ctx.Get("provider", ""); p != "" {
-		return p, nil
+	if !ProviderNameSessionFallback {
+		return "", ErrNoProvider
 	}
 
-	// try to get it from the Fiber context's Locals
-	if p, ok := ctx.Locals(ProviderParamKey).(string); ok && p != "" {
-		return p, nil
+	// As a fallback, loop over the used providers, if we already have a valid session for any provider (ie. user has already begun authentication with a provider), then return that provider name
+	providers, err := availableProviders(ctx)
+	if err != nil {
+		return "", err
 	}
+	for p := range addAliases(providers) {
+		if checkAllowedProvider(p) != nil {
+			continue
+		}
 
-	// As a fallback, loop over the used providers, if we already have a valid session for any provider (ie. user has already begun authentication with a provider), then return that provider name
-	providers := goth.GetProviders()
-	for _, provider := range providers {
-		p := provider.Name()
 		_, err := SessionManager.getValue(ctx, p)
 		if err == nil {
 			return p, nil
@@ -272,7 +493,7 @@ func GetProviderName(ctx fiber.Ctx) (string, error) {
 	}
 
 	// if not found then return an empty string with the corresponding error
-	return "", errors.New("you must select a provider")
+	return "", ErrNoProvider
 }
 
 // GetContextWithProvider returns a new request context containing the provider
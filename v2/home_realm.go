@@ -0,0 +1,148 @@
+package goth_fiber
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/gofiber/fiber/v3"
+)
+
+// ErrNoHomeRealm is returned by DiscoverHomeRealm when no rule matches the
+// domain of the email, or the email is malformed.
+var ErrNoHomeRealm = errors.New("goth_fiber: no provider for this email domain")
+
+// RealmRule maps email domains to a provider name or alias.
+type RealmRule struct {
+	// Domain is matched against the domain of the email: "example.com"
+	// matches exactly, "*.example.com" matches its subdomains and "*"
+	// matches any domain. The most specific matching rule wins.
+	Domain string
+
+	// Tenant restricts the rule to the requests of a tenant, see
+	// HomeRealmOptions.Tenant. Rules of the tenant take precedence over
+	// the rules without a tenant.
+	Tenant string
+
+	// Provider is the provider name or alias to log in with.
+	Provider string
+}
+
+// Options that affect how HomeRealmHandler works.
+type HomeRealmOptions struct {
+	// Rules map email domains to providers.
+	Rules []RealmRule
+
+	// Tenant returns the tenant of a request, for rules restricted to a tenant.
+	//
+	// Defaults to no tenant.
+	Tenant TenantFunc
+
+	// EmailParam is the query or form parameter holding the email.
+	//
+	// Defaults to "email".
+	EmailParam string
+
+	// ErrorHandler is called when no provider can be found for the email.
+	//
+	// Defaults to responding with ErrorStatus and the error message, like
+	// BeginAuthHandler.
+	ErrorHandler func(ctx fiber.Ctx, err error) error
+}
+
+/*
+DiscoverHomeRealm returns the provider of the first most specific rule
+matching the domain of email, for a request of tenant.
+*/
+func DiscoverHomeRealm(email, tenant string, rules []RealmRule) (string, error) {
+	email = strings.TrimSpace(email)
+	at := strings.LastIndexByte(email, '@')
+	if at < 1 || at == len(email)-1 {
+		return "", fmt.Errorf("%w: invalid email", ErrNoHomeRealm)
+	}
+	domain := strings.ToLower(email[at+1:])
+
+	best, bestScore := "", -1
+	for _, rule := range rules {
+		if rule.Tenant != "" && rule.Tenant != tenant {
+			continue
+		}
+
+		specificity, ok := matchRealmDomain(strings.ToLower(rule.Domain), domain)
+		if !ok {
+			continue
+		}
+
+		// tenant rules first, then the longest pattern, exact before wildcard
+		score := specificity * 2
+		if rule.Tenant != "" {
+			score += 1 << 20
+		}
+		if score > bestScore {
+			best, bestScore = rule.Provider, score
+		}
+	}
+
+	if best == "" {
+		return "", fmt.Errorf("%w: %s", ErrNoHomeRealm, domain)
+	}
+	return best, nil
+}
+
+// matchRealmDomain reports whether pattern matches domain, and how specific the pattern is.
+func matchRealmDomain(pattern, domain string) (int, bool) {
+	switch {
+	case pattern == "*":
+		return 0, true
+	case strings.HasPrefix(pattern, "*."):
+		return len(pattern) - 1, strings.HasSuffix(domain, pattern[1:])
+	default:
+		return len(pattern) + 1, pattern == domain
+	}
+}
+
+/*
+HomeRealmHandler returns a handler starting the authentication with the
+provider the email of the request belongs to, following HomeRealmOptions.Rules.
+The email is passed to the provider as login_hint. The discovered provider
+takes precedence over a provider name in the route or query, see
+GetContextWithProvider.
+*/
+func HomeRealmHandler(options ...HomeRealmOptions) fiber.Handler {
+	var opts HomeRealmOptions
+	if len(options) > 0 {
+		opts = options[0]
+	}
+
+	if opts.EmailParam == "" {
+		opts.EmailParam = "email"
+	}
+
+	if opts.ErrorHandler == nil {
+		opts.ErrorHandler = func(ctx fiber.Ctx, err error) error {
+			return ctx.Status(ErrorStatus(err)).SendString(err.Error())
+		}
+	}
+
+	return func(ctx fiber.Ctx) error {
+		email := ctx.Query(opts.EmailParam)
+		if email == "" {
+			email = ctx.FormValue(opts.EmailParam)
+		}
+
+		tenant := ""
+		if opts.Tenant != nil {
+			tenant = opts.Tenant(ctx)
+		}
+
+		provider, err := DiscoverHomeRealm(email, tenant, opts.Rules)
+		if err != nil {
+			return opts.ErrorHandler(ctx, err)
+		}
+
+		GetContextWithProvider(ctx, provider)
+		GetContextWithAuthParams(ctx, url.Values{"login_hint": {strings.TrimSpace(email)}})
+		return BeginAuthHandler(ctx)
+	}
+}
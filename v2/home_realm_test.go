@@ -0,0 +1,88 @@
+package goth_fiber
+
+import (
+	"errors"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/markbates/goth"
+)
+
+func Test_DiscoverHomeRealm(t *testing.T) {
+	t.Parallel()
+
+	rules := []RealmRule{
+		{Domain: "*", Provider: "google"},
+		{Domain: "*.acme.com", Provider: "okta-acme"},
+		{Domain: "eu.acme.com", Provider: "okta-eu"},
+		{Domain: "globex.com", Provider: "azure"},
+		{Domain: "globex.com", Tenant: "partner", Provider: "azure-partner"},
+	}
+
+	tests := []struct {
+		email  string
+		tenant string
+		want   string
+	}{
+		{"jane@eu.acme.com", "", "okta-eu"},
+		{"jane@us.acme.com", "", "okta-acme"},
+		{"Jane@GLOBEX.com", "", "azure"},
+		{"jane@globex.com", "partner", "azure-partner"},
+		{"jane@gmail.com", "", "google"},
+	}
+
+	for _, tt := range tests {
+		got, err := DiscoverHomeRealm(tt.email, tt.tenant, rules)
+		if err != nil || got != tt.want {
+			t.Errorf("DiscoverHomeRealm(%s, %s) = %s, %v, want %s", tt.email, tt.tenant, got, err, tt.want)
+		}
+	}
+
+	for _, email := range []string{"", "jane", "@acme.com", "jane@"} {
+		if _, err := DiscoverHomeRealm(email, "", rules); !errors.Is(err, ErrNoHomeRealm) {
+			t.Errorf("expected ErrNoHomeRealm for %q, got %v", email, err)
+		}
+	}
+
+	if _, err := DiscoverHomeRealm("jane@acme.com", "", rules[1:2]); !errors.Is(err, ErrNoHomeRealm) {
+		t.Errorf("expected wildcards not to match the bare domain, got %v", err)
+	}
+}
+
+func Test_HomeRealmHandler(t *testing.T) {
+	server := newFakeAuthServer(t)
+
+	goth.ClearProviders()
+	goth.UseProviders(newTestOAuth2Provider(server, "okta", "http://localhost/callback/okta", nil))
+
+	app := fiber.New()
+	app.Post("/login", HomeRealmHandler(HomeRealmOptions{
+		Rules: []RealmRule{{Domain: "acme.com", Provider: "okta"}},
+	}))
+
+	req := httptest.NewRequest("POST", "/login", strings.NewReader("email="+url.QueryEscape("jane@acme.com")))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != fiber.StatusTemporaryRedirect {
+		t.Fatalf("expected a redirect, got %d", resp.StatusCode)
+	}
+
+	location, _ := url.Parse(resp.Header.Get("Location"))
+	if location.Path != "/authorize" || location.Query().Get("login_hint") != "jane@acme.com" {
+		t.Errorf("expected the okta authorize URL with a login_hint, got %s", location)
+	}
+
+	resp, err = app.Test(httptest.NewRequest("POST", "/login?email=jane@example.com", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != fiber.StatusBadRequest {
+		t.Errorf("expected unknown domains to be rejected, got %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,104 @@
+package goth_fiber
+
+import (
+	"github.com/gofiber/fiber/v3"
+	"github.com/markbates/goth"
+)
+
+// Options that affect how the JSON handlers work.
+type JSONOptions struct {
+	// Summary returns the user data of the callback response.
+	//
+	// Defaults to the provider, user id, name, email and avatar URL.
+	Summary func(user goth.User) map[string]interface{}
+
+	// KeepSession keeps the session once the authentication is completed,
+	// see CompleteUserAuthOptions.
+	//
+	// Defaults to false.
+	KeepSession bool
+
+	// ErrorHandler renders errors.
+	//
+	// Defaults to JSONErrorHandler.
+	ErrorHandler func(ctx fiber.Ctx, err error) error
+}
+
+// AuthURLResponse is the body returned by AuthURLHandler.
+type AuthURLResponse struct {
+	Provider string `json:"provider"`
+	AuthURL  string `json:"auth_url"`
+	State    string `json:"state,omitempty"`
+}
+
+// CallbackResponse is the body returned by JSONCallbackHandler.
+type CallbackResponse struct {
+	Provider string                 `json:"provider"`
+	User     map[string]interface{} `json:"user"`
+}
+
+/*
+AuthURLHandler returns a JSON variant of BeginAuthHandler for clients that
+navigate to the provider themselves. Instead of redirecting, it responds with
+the provider name, the URL to send the user to and the state of the request.
+*/
+func AuthURLHandler(options ...JSONOptions) fiber.Handler {
+	opts := jsonOptions(options)
+
+	return func(ctx fiber.Ctx) error {
+		authURL, err := GetAuthURL(ctx)
+		if err != nil {
+			return opts.ErrorHandler(ctx, err)
+		}
+
+		providerName, err := GetProviderName(ctx)
+		if err != nil {
+			return opts.ErrorHandler(ctx, err)
+		}
+
+		// the auth URL only holds a request_uri with pushed authorization
+		// requests, the state is the one GetAuthURL sent
+		state, _ := ctx.Locals(stateKey).(string)
+		res := AuthURLResponse{Provider: providerName, AuthURL: authURL, State: state}
+
+		return ctx.JSON(res)
+	}
+}
+
+/*
+JSONCallbackHandler returns a JSON variant of a callback handler. It completes
+the authentication and responds with the user, or with an error body rendered
+by the ErrorHandler.
+*/
+func JSONCallbackHandler(options ...JSONOptions) fiber.Handler {
+	opts := jsonOptions(options)
+
+	return func(ctx fiber.Ctx) error {
+		user, err := CompleteUserAuth(ctx, CompleteUserAuthOptions{ShouldLogout: !opts.KeepSession})
+		if err != nil {
+			return opts.ErrorHandler(ctx, err)
+		}
+
+		return ctx.JSON(CallbackResponse{
+			Provider: user.Provider,
+			User:     opts.Summary(user),
+		})
+	}
+}
+
+func jsonOptions(options []JSONOptions) JSONOptions {
+	var opts JSONOptions
+	if len(options) > 0 {
+		opts = options[0]
+	}
+
+	if opts.Summary == nil {
+		opts.Summary = userSummary
+	}
+
+	if opts.ErrorHandler == nil {
+		opts.ErrorHandler = JSONErrorHandler
+	}
+
+	return opts
+}
@@ -0,0 +1,123 @@
+package goth_fiber
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/markbates/goth"
+	"github.com/markbates/goth/providers/faux"
+)
+
+func Test_JSONHandlers(t *testing.T) {
+	goth.ClearProviders()
+	goth.UseProviders(&faux.Provider{})
+
+	app := fiber.New()
+	app.Get("/auth/:provider/url", AuthURLHandler())
+	app.Get("/callback/:provider", JSONCallbackHandler())
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/auth/faux/url?state=test-state", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var authURL AuthURLResponse
+	if err := json.NewDecoder(resp.Body).Decode(&authURL); err != nil {
+		t.Fatal(err)
+	}
+	if authURL.Provider != "faux" || authURL.State != "test-state" || authURL.AuthURL == "" {
+		t.Fatalf("unexpected auth url response %+v", authURL)
+	}
+	cookies := resp.Cookies()
+
+	req := httptest.NewRequest("GET", "/callback/faux?code=test-code&state=test-state", nil)
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+	resp, err = app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var callback CallbackResponse
+	if err := json.NewDecoder(resp.Body).Decode(&callback); err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 200 || callback.Provider != "faux" || callback.User["user_id"] != "id" {
+		t.Errorf("unexpected callback response %d: %+v", resp.StatusCode, callback)
+	}
+}
+
+func Test_JSONHandlers_Errors(t *testing.T) {
+	goth.ClearProviders()
+	goth.UseProviders(&faux.Provider{})
+
+	app := fiber.New()
+	app.Get("/auth/:provider/url", AuthURLHandler())
+	app.Get("/callback/:provider", JSONCallbackHandler())
+
+	tests := []struct {
+		path   string
+		status int
+		code   string
+	}{
+		{"/auth/unknown/url", fiber.StatusBadRequest, "unknown_provider"},
+		{"/callback/faux?code=test-code", fiber.StatusBadRequest, "session_not_found"},
+	}
+
+	for _, tt := range tests {
+		resp, err := app.Test(httptest.NewRequest("GET", tt.path, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var body map[string]string
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != tt.status || body["error"] != tt.code {
+			t.Errorf("%s: expected %d %s, got %d %v", tt.path, tt.status, tt.code, resp.StatusCode, body)
+		}
+	}
+}
+
+func Test_AuthURLHandler_PushedAuthorization(t *testing.T) {
+	server := newFakeAuthServer(t)
+
+	goth.ClearProviders()
+	goth.UseProviders(newTestOAuth2Provider(server, "par", "http://localhost/callback/par", nil))
+	SetProviderOptions("par", ProviderOptions{
+		PushedAuthorizationURL: server.URL + "/par",
+		ClientSecret:           "secret",
+	})
+	defer SetProviderOptions("par", ProviderOptions{})
+
+	app := fiber.New()
+	app.Get("/auth/:provider/url", AuthURLHandler())
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/auth/par/url", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var authURL AuthURLResponse
+	if err := json.NewDecoder(resp.Body).Decode(&authURL); err != nil {
+		t.Fatal(err)
+	}
+
+	location, err := url.Parse(authURL.AuthURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if location.Query().Get("state") != "" || location.Query().Get("request_uri") == "" {
+		t.Fatalf("expected a pushed authorization request, got %s", authURL.AuthURL)
+	}
+
+	pushed := server.pushed[location.Query().Get("request_uri")]
+	if authURL.State == "" || authURL.State != pushed.Get("state") {
+		t.Errorf("expected the pushed state %q, got %q", pushed.Get("state"), authURL.State)
+	}
+}
@@ -0,0 +1,252 @@
+package goth_fiber
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// jwksMinRefresh limits how often an unknown kid can trigger a JWKS refetch.
+const jwksMinRefresh = time.Minute
+
+// jsonWebKey is a single key of a JSON Web Key Set (RFC 7517).
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// publicKey returns the *rsa.PublicKey, *ecdsa.PublicKey or ed25519.PublicKey described by the key.
+func (k jsonWebKey) publicKey() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		curve, err := jwkCurve(k.Crv)
+		if err != nil {
+			return nil, err
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("goth_fiber: unsupported jwk curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("goth_fiber: malformed Ed25519 jwk")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+
+	return nil, fmt.Errorf("goth_fiber: unsupported jwk type %q", k.Kty)
+}
+
+// publicJWK describes a public key as a JSON Web Key.
+func publicJWK(key interface{}) (jsonWebKey, error) {
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		return jsonWebKey{
+			Kty: "RSA",
+			N:   base64.RawURLEncoding.EncodeToString(k.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.E)).Bytes()),
+		}, nil
+	case *ecdsa.PublicKey:
+		size := (k.Curve.Params().BitSize + 7) / 8
+		x, y := make([]byte, size), make([]byte, size)
+		k.X.FillBytes(x)
+		k.Y.FillBytes(y)
+		return jsonWebKey{
+			Kty: "EC",
+			Crv: k.Curve.Params().Name,
+			X:   base64.RawURLEncoding.EncodeToString(x),
+			Y:   base64.RawURLEncoding.EncodeToString(y),
+		}, nil
+	case ed25519.PublicKey:
+		return jsonWebKey{
+			Kty: "OKP",
+			Crv: "Ed25519",
+			X:   base64.RawURLEncoding.EncodeToString(k),
+		}, nil
+	}
+
+	return jsonWebKey{}, fmt.Errorf("goth_fiber: unsupported public key type %T", key)
+}
+
+func jwkCurve(crv string) (elliptic.Curve, error) {
+	switch crv {
+	case "P-256":
+		return elliptic.P256(), nil
+	case "P-384":
+		return elliptic.P384(), nil
+	case "P-521":
+		return elliptic.P521(), nil
+	}
+
+	return nil, fmt.Errorf("goth_fiber: unsupported jwk curve %q", crv)
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) == 0 {
+		return nil, fmt.Errorf("goth_fiber: malformed jwk")
+	}
+
+	return new(big.Int).SetBytes(b), nil
+}
+
+// jwksCache fetches a JSON Web Key Set and keeps its keys by kid. The set is
+// fetched without holding the lock, once at a time: concurrent lookups of
+// unknown kids wait for the fetch in flight.
+type jwksCache struct {
+	url    string
+	client *http.Client
+
+	mu       sync.Mutex
+	keys     map[string]interface{}
+	fetched  time.Time
+	inflight *jwksFetch
+}
+
+// jwksFetch is a fetch of the set, done is closed once it completed.
+type jwksFetch struct {
+	done chan struct{}
+	err  error
+}
+
+func newJWKSCache(url string, client *http.Client) *jwksCache {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	return &jwksCache{url: url, client: client}
+}
+
+// key returns the key with the given kid, refetching the set when the kid is unknown.
+func (c *jwksCache) key(kid string) (interface{}, error) {
+	c.mu.Lock()
+	if key, ok := c.lookup(kid); ok {
+		c.mu.Unlock()
+		return key, nil
+	}
+
+	f := c.inflight
+	if f == nil {
+		if time.Since(c.fetched) < jwksMinRefresh {
+			c.mu.Unlock()
+			return nil, fmt.Errorf("%w: unknown key id %q", ErrInvalidToken, kid)
+		}
+
+		f = &jwksFetch{done: make(chan struct{})}
+		c.inflight = f
+		c.fetched = time.Now()
+		c.mu.Unlock()
+
+		keys, err := c.fetch()
+
+		c.mu.Lock()
+		if err == nil {
+			c.keys = keys
+		}
+		f.err = err
+		c.inflight = nil
+		c.mu.Unlock()
+		close(f.done)
+	} else {
+		c.mu.Unlock()
+		<-f.done
+	}
+
+	if f.err != nil {
+		return nil, f.err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if key, ok := c.lookup(kid); ok {
+		return key, nil
+	}
+
+	return nil, fmt.Errorf("%w: unknown key id %q", ErrInvalidToken, kid)
+}
+
+// lookup returns the cached key with the given kid, the caller must hold c.mu.
+func (c *jwksCache) lookup(kid string) (interface{}, bool) {
+	if key, ok := c.keys[kid]; ok {
+		return key, true
+	}
+
+	// tokens without a kid can only be matched against a set with a single key
+	if kid == "" && len(c.keys) == 1 {
+		for _, key := range c.keys {
+			return key, true
+		}
+	}
+
+	return nil, false
+}
+
+// fetch downloads the set and returns its keys.
+func (c *jwksCache) fetch() (map[string]interface{}, error) {
+	resp, err := c.client.Get(c.url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("goth_fiber: fetching jwks returned %s", resp.Status)
+	}
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]interface{}, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			// skip keys we do not understand instead of failing the whole set
+			continue
+		}
+		keys[k.Kid] = key
+	}
+
+	return keys, nil
+}
@@ -0,0 +1,273 @@
+package goth_fiber
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// ErrInvalidToken is returned when a JWT or bearer token is malformed, has a
+// bad signature, is expired or is otherwise not acceptable.
+var ErrInvalidToken = errors.New("goth_fiber: invalid token")
+
+// jwtHeader is the JOSE header of a JWT.
+type jwtHeader struct {
+	Alg string          `json:"alg"`
+	Typ string          `json:"typ,omitempty"`
+	Kid string          `json:"kid,omitempty"`
+	JWK json.RawMessage `json:"jwk,omitempty"`
+}
+
+// parsedJWT is a JWT split into its parts, the signature not yet verified.
+type parsedJWT struct {
+	header       jwtHeader
+	claims       map[string]interface{}
+	signingInput string
+	signature    []byte
+}
+
+func parseJWT(token string) (*parsedJWT, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: malformed jwt", ErrInvalidToken)
+	}
+
+	var t parsedJWT
+	if err := decodeJWTPart(parts[0], &t.header); err != nil {
+		return nil, err
+	}
+
+	if err := decodeJWTPart(parts[1], &t.claims); err != nil {
+		return nil, err
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed signature", ErrInvalidToken)
+	}
+
+	t.signingInput = parts[0] + "." + parts[1]
+	t.signature = sig
+	return &t, nil
+}
+
+func decodeJWTPart(part string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return fmt.Errorf("%w: malformed jwt", ErrInvalidToken)
+	}
+
+	if err := json.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("%w: malformed jwt", ErrInvalidToken)
+	}
+
+	return nil
+}
+
+// signJWT signs claims with key using header.Alg. key is a []byte for the HS
+// algorithms, or an *rsa.PrivateKey, *ecdsa.PrivateKey or ed25519.PrivateKey.
+func signJWT(header jwtHeader, claims interface{}, key interface{}) (string, error) {
+	if header.Typ == "" {
+		header.Typ = "JWT"
+	}
+
+	h, err := json.Marshal(header)
+	if err != nil {
+		return "", err
+	}
+
+	c, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(c)
+	sig, err := jwtSign(header.Alg, key, []byte(signingInput))
+	if err != nil {
+		return "", err
+	}
+
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+func jwtHash(alg string) (crypto.Hash, error) {
+	switch {
+	case strings.HasSuffix(alg, "256"):
+		return crypto.SHA256, nil
+	case strings.HasSuffix(alg, "384"):
+		return crypto.SHA384, nil
+	case strings.HasSuffix(alg, "512"):
+		return crypto.SHA512, nil
+	}
+
+	return 0, fmt.Errorf("goth_fiber: unsupported jwt algorithm %q", alg)
+}
+
+func jwtSign(alg string, key interface{}, input []byte) ([]byte, error) {
+	if alg == "EdDSA" {
+		k, ok := key.(ed25519.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("goth_fiber: %s requires an ed25519.PrivateKey", alg)
+		}
+		return ed25519.Sign(k, input), nil
+	}
+
+	hash, err := jwtHash(alg)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case strings.HasPrefix(alg, "HS"):
+		k, ok := key.([]byte)
+		if !ok {
+			return nil, fmt.Errorf("goth_fiber: %s requires a []byte secret", alg)
+		}
+		mac := hmac.New(hash.New, k)
+		mac.Write(input)
+		return mac.Sum(nil), nil
+	case strings.HasPrefix(alg, "RS"):
+		k, ok := key.(*rsa.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("goth_fiber: %s requires an *rsa.PrivateKey", alg)
+		}
+		return rsa.SignPKCS1v15(rand.Reader, k, hash, digest(hash, input))
+	case strings.HasPrefix(alg, "ES"):
+		k, ok := key.(*ecdsa.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("goth_fiber: %s requires an *ecdsa.PrivateKey", alg)
+		}
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest(hash, input))
+		if err != nil {
+			return nil, err
+		}
+		size := (k.Curve.Params().BitSize + 7) / 8
+		sig := make([]byte, 2*size)
+		r.FillBytes(sig[:size])
+		s.FillBytes(sig[size:])
+		return sig, nil
+	}
+
+	return nil, fmt.Errorf("goth_fiber: unsupported jwt algorithm %q", alg)
+}
+
+// verify checks the signature of the token with key, which is a []byte for
+// the HS algorithms or the matching public key otherwise.
+func (t *parsedJWT) verify(key interface{}) error {
+	alg := t.header.Alg
+	input := []byte(t.signingInput)
+
+	if alg == "EdDSA" {
+		k, ok := key.(ed25519.PublicKey)
+		if !ok || !ed25519.Verify(k, input, t.signature) {
+			return fmt.Errorf("%w: bad signature", ErrInvalidToken)
+		}
+		return nil
+	}
+
+	hash, err := jwtHash(alg)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+
+	switch {
+	case strings.HasPrefix(alg, "HS"):
+		k, ok := key.([]byte)
+		if ok {
+			mac := hmac.New(hash.New, k)
+			mac.Write(input)
+			if hmac.Equal(mac.Sum(nil), t.signature) {
+				return nil
+			}
+		}
+	case strings.HasPrefix(alg, "RS"):
+		k, ok := key.(*rsa.PublicKey)
+		if ok && rsa.VerifyPKCS1v15(k, hash, digest(hash, input), t.signature) == nil {
+			return nil
+		}
+	case strings.HasPrefix(alg, "ES"):
+		k, ok := key.(*ecdsa.PublicKey)
+		if ok {
+			size := (k.Curve.Params().BitSize + 7) / 8
+			if len(t.signature) == 2*size {
+				r := new(big.Int).SetBytes(t.signature[:size])
+				s := new(big.Int).SetBytes(t.signature[size:])
+				if ecdsa.Verify(k, digest(hash, input), r, s) {
+					return nil
+				}
+			}
+		}
+	default:
+		return fmt.Errorf("%w: unsupported algorithm %q", ErrInvalidToken, alg)
+	}
+
+	return fmt.Errorf("%w: bad signature", ErrInvalidToken)
+}
+
+// validateClaims checks the time based claims and, when not empty, the issuer and audience.
+func (t *parsedJWT) validateClaims(issuer, audience string, leeway time.Duration) error {
+	now := time.Now()
+
+	if exp, ok := numericClaim(t.claims, "exp"); ok && now.After(exp.Add(leeway)) {
+		return fmt.Errorf("%w: token is expired", ErrInvalidToken)
+	}
+
+	if nbf, ok := numericClaim(t.claims, "nbf"); ok && now.Add(leeway).Before(nbf) {
+		return fmt.Errorf("%w: token is not valid yet", ErrInvalidToken)
+	}
+
+	if issuer != "" && t.claims["iss"] != issuer {
+		return fmt.Errorf("%w: unexpected issuer", ErrInvalidToken)
+	}
+
+	if audience != "" && !hasAudience(t.claims["aud"], audience) {
+		return fmt.Errorf("%w: unexpected audience", ErrInvalidToken)
+	}
+
+	return nil
+}
+
+func numericClaim(claims map[string]interface{}, name string) (time.Time, bool) {
+	v, ok := claims[name].(float64)
+	if !ok {
+		return time.Time{}, false
+	}
+
+	return time.Unix(int64(v), 0), true
+}
+
+func stringClaim(claims map[string]interface{}, name string) string {
+	s, _ := claims[name].(string)
+	return s
+}
+
+func hasAudience(aud interface{}, audience string) bool {
+	switch v := aud.(type) {
+	case string:
+		return v == audience
+	case []interface{}:
+		for _, a := range v {
+			if a == audience {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func digest(hash crypto.Hash, input []byte) []byte {
+	h := hash.New()
+	h.Write(input)
+	return h.Sum(nil)
+}
@@ -0,0 +1,133 @@
+package goth_fiber
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"testing"
+	"time"
+)
+
+func Test_JWT_SignAndVerify(t *testing.T) {
+	t.Parallel()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edPub, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		alg     string
+		signKey interface{}
+		pubKey  interface{}
+	}{
+		{"HS256", []byte("secret"), []byte("secret")},
+		{"RS256", rsaKey, &rsaKey.PublicKey},
+		{"ES256", ecKey, &ecKey.PublicKey},
+		{"EdDSA", edKey, edPub},
+	}
+
+	for _, tt := range tests {
+		token, err := signJWT(jwtHeader{Alg: tt.alg}, map[string]interface{}{"sub": "42"}, tt.signKey)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.alg, err)
+		}
+
+		parsed, err := parseJWT(token)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.alg, err)
+		}
+		if err := parsed.verify(tt.pubKey); err != nil {
+			t.Errorf("%s: expected valid signature, got %v", tt.alg, err)
+		}
+		if err := parsed.verify([]byte("other")); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("%s: expected ErrInvalidToken with wrong key, got %v", tt.alg, err)
+		}
+	}
+}
+
+func Test_JWT_ValidateClaims(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	tests := []struct {
+		name   string
+		claims map[string]interface{}
+		valid  bool
+	}{
+		{"valid", map[string]interface{}{"exp": float64(now.Add(time.Hour).Unix()), "iss": "https://issuer", "aud": "api"}, true},
+		{"audience list", map[string]interface{}{"iss": "https://issuer", "aud": []interface{}{"other", "api"}}, true},
+		{"expired", map[string]interface{}{"exp": float64(now.Add(-time.Hour).Unix()), "iss": "https://issuer", "aud": "api"}, false},
+		{"not yet valid", map[string]interface{}{"nbf": float64(now.Add(time.Hour).Unix()), "iss": "https://issuer", "aud": "api"}, false},
+		{"wrong issuer", map[string]interface{}{"iss": "https://evil", "aud": "api"}, false},
+		{"wrong audience", map[string]interface{}{"iss": "https://issuer", "aud": "other"}, false},
+	}
+
+	for _, tt := range tests {
+		parsed := parsedJWT{claims: tt.claims}
+		err := parsed.validateClaims("https://issuer", "api", 0)
+		if (err == nil) != tt.valid {
+			t.Errorf("%s: unexpected result %v", tt.name, err)
+		}
+	}
+}
+
+func Test_JWT_RejectsNone(t *testing.T) {
+	t.Parallel()
+
+	parsed, err := parseJWT("eyJhbGciOiJub25lIn0.eyJzdWIiOiI0MiJ9.")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := parsed.verify([]byte("secret")); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("expected alg none to be rejected, got %v", err)
+	}
+}
+
+func Test_JWK_RoundTrip(t *testing.T) {
+	t.Parallel()
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edPub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, pub := range []interface{}{&ecKey.PublicKey, edPub} {
+		jwk, err := publicJWK(pub)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		key, err := jwk.publicKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		switch k := key.(type) {
+		case *ecdsa.PublicKey:
+			if !k.Equal(pub) {
+				t.Error("ecdsa key changed in jwk round trip")
+			}
+		case ed25519.PublicKey:
+			if !k.Equal(pub) {
+				t.Error("ed25519 key changed in jwk round trip")
+			}
+		}
+	}
+}
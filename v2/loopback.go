@@ -0,0 +1,173 @@
+package goth_fiber
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"runtime"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/markbates/goth"
+	"golang.org/x/oauth2"
+)
+
+// ErrLoginTimeout is returned by LoopbackLogin when the user does not finish
+// logging in before the timeout.
+var ErrLoginTimeout = errors.New("goth_fiber: login timed out")
+
+// Browser opens a URL for the user to log in with.
+type Browser interface {
+	Open(url string) error
+}
+
+// BrowserFunc adapts a function to the Browser interface.
+type BrowserFunc func(url string) error
+
+// Open calls f(url).
+func (f BrowserFunc) Open(url string) error {
+	return f(url)
+}
+
+// SystemBrowser opens URLs with the default browser of the operating system.
+var SystemBrowser Browser = BrowserFunc(func(url string) error {
+	switch runtime.GOOS {
+	case "darwin":
+		return exec.Command("open", url).Start()
+	case "windows":
+		return exec.Command("rundll32", "url.dll,FileProtocolHandler", url).Start()
+	default:
+		return exec.Command("xdg-open", url).Start()
+	}
+})
+
+// Options that affect how LoopbackLogin works.
+type LoopbackOptions struct {
+	// Provider builds the provider to log in with for the given loopback
+	// redirect URL. client must be set as the HTTP client of the provider so
+	// that the PKCE code verifier reaches the token endpoint.
+	Provider func(redirectURL string, client *http.Client) (goth.Provider, error)
+
+	// Browser opens the login URL. The URL is always written to Output too,
+	// in case the browser cannot be opened.
+	//
+	// Defaults to no browser.
+	Browser Browser
+
+	// Output is where the login URL is written to.
+	//
+	// Defaults to os.Stderr.
+	Output io.Writer
+
+	// Timeout for the user to complete the login.
+	//
+	// Defaults to 5 minutes.
+	Timeout time.Duration
+
+	// CallbackPath is the path of the loopback redirect URL.
+	//
+	// Defaults to "/callback".
+	CallbackPath string
+}
+
+type loopbackResult struct {
+	user goth.User
+	err  error
+}
+
+/*
+LoopbackLogin logs a user in from a native app or CLI tool, following RFC 8252.
+It listens on an ephemeral port of 127.0.0.1, sends the user to a local login
+URL that runs GetAuthURL with PKCE, and waits for the provider to redirect back
+to the loopback callback, where CompleteUserAuth finishes the login.
+
+It returns the user and its tokens, ErrLoginTimeout when the timeout expires,
+or the error of ctx when it is done first.
+*/
+func LoopbackLogin(ctx context.Context, opts LoopbackOptions) (goth.User, *oauth2.Token, error) {
+	if opts.Provider == nil {
+		return goth.User{}, nil, errors.New("goth_fiber: LoopbackOptions.Provider is required")
+	}
+
+	if opts.Output == nil {
+		opts.Output = os.Stderr
+	}
+
+	if opts.Timeout == 0 {
+		opts.Timeout = 5 * time.Minute
+	}
+
+	if opts.CallbackPath == "" {
+		opts.CallbackPath = "/callback"
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return goth.User{}, nil, err
+	}
+	defer ln.Close()
+
+	base := fmt.Sprintf("http://%s", ln.Addr().String())
+	provider, err := opts.Provider(base+opts.CallbackPath, ExchangeClient(nil))
+	if err != nil {
+		return goth.User{}, nil, err
+	}
+
+	results := make(chan loopbackResult, 1)
+	withProvider := func(c fiber.Ctx) error {
+		c.Locals(providerInstanceKey, provider)
+		GetContextWithProvider(c, provider.Name())
+		return c.Next()
+	}
+
+	app := fiber.New()
+	app.Get("/login", withProvider, func(c fiber.Ctx) error {
+		return BeginAuthHandler(GetContextWithPKCE(c))
+	})
+	app.Get(opts.CallbackPath, withProvider, func(c fiber.Ctx) error {
+		user, err := CompleteUserAuth(c)
+
+		select {
+		case results <- loopbackResult{user: user, err: err}:
+		default:
+		}
+
+		if err != nil {
+			return c.Status(ErrorStatus(err)).SendString("Login failed: " + err.Error())
+		}
+		return c.SendString("You are logged in, you can close this window.")
+	})
+
+	go func() {
+		_ = app.Listener(ln, fiber.ListenConfig{DisableStartupMessage: true})
+	}()
+	defer app.Shutdown()
+
+	loginURL := base + "/login"
+	fmt.Fprintf(opts.Output, "Open the following URL to log in:\n\n    %s\n\n", loginURL)
+	if opts.Browser != nil {
+		if err := opts.Browser.Open(loginURL); err != nil {
+			fmt.Fprintf(opts.Output, "Could not open a browser: %v\n", err)
+		}
+	}
+
+	timer := time.NewTimer(opts.Timeout)
+	defer timer.Stop()
+
+	select {
+	case res := <-results:
+		if res.err != nil {
+			return goth.User{}, nil, res.err
+		}
+		return res.user, tokenFromUser(res.user, ""), nil
+	case <-timer.C:
+		return goth.User{}, nil, ErrLoginTimeout
+	case <-ctx.Done():
+		return goth.User{}, nil, ctx.Err()
+	}
+}
@@ -0,0 +1,98 @@
+package goth_fiber
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/markbates/goth"
+)
+
+// testBrowser follows the login flow like a user would, with the fake
+// authorization server approving the request right away.
+func testBrowser(t *testing.T, server *fakeAuthServer) Browser {
+	return BrowserFunc(func(loginURL string) error {
+		jar, _ := cookiejar.New(nil)
+		client := &http.Client{
+			Jar: jar,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		}
+
+		go func() {
+			resp, err := client.Get(loginURL)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			resp.Body.Close()
+
+			authURL, err := url.Parse(resp.Header.Get("Location"))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			callback, _ := url.Parse(authURL.Query().Get("redirect_uri"))
+			callback.RawQuery = url.Values{
+				"code":  {server.issueCode(authURL.String())},
+				"state": {authURL.Query().Get("state")},
+			}.Encode()
+
+			resp, err = client.Get(callback.String())
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			resp.Body.Close()
+		}()
+
+		return nil
+	})
+}
+
+func Test_LoopbackLogin(t *testing.T) {
+	server := newFakeAuthServer(t)
+
+	user, token, err := LoopbackLogin(context.Background(), LoopbackOptions{
+		Provider: func(redirectURL string, client *http.Client) (goth.Provider, error) {
+			return newTestOAuth2Provider(server, "loopback", redirectURL, client), nil
+		},
+		Browser: testBrowser(t, server),
+		Output:  io.Discard,
+		Timeout: 5 * time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if user.UserID != "42" || token.AccessToken == "" || token.RefreshToken != "rt" {
+		t.Errorf("unexpected login result %+v %+v", user, token)
+	}
+
+	form := server.lastTokenForm()
+	if form.Get("code_verifier") == "" {
+		t.Error("expected the code exchange to carry a PKCE code verifier")
+	}
+}
+
+func Test_LoopbackLogin_Timeout(t *testing.T) {
+	server := newFakeAuthServer(t)
+
+	_, _, err := LoopbackLogin(context.Background(), LoopbackOptions{
+		Provider: func(redirectURL string, client *http.Client) (goth.Provider, error) {
+			return newTestOAuth2Provider(server, "loopback", redirectURL, client), nil
+		},
+		Output:  io.Discard,
+		Timeout: 50 * time.Millisecond,
+	})
+	if !errors.Is(err, ErrLoginTimeout) {
+		t.Errorf("expected ErrLoginTimeout, got %v", err)
+	}
+}
@@ -0,0 +1,320 @@
+package goth_fiber
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/markbates/goth"
+	"golang.org/x/oauth2"
+)
+
+// fakeAuthServer is a minimal OAuth2 authorization server for tests. Codes
+// are issued by the test through issueCode, which remembers the PKCE challenge.
+type fakeAuthServer struct {
+	*httptest.Server
+
+	mu          sync.Mutex
+	challenges  map[string]string
+	scopes      map[string]string
+	tokenForms  []url.Values
+	devicePolls int
+	pushed      map[string]url.Values
+	dpopProofs  []string
+	dpopTokens  map[string]string
+
+	// subject is the "sub" of the user info, "42" if empty
+	subject string
+}
+
+func newFakeAuthServer(t *testing.T) *fakeAuthServer {
+	t.Helper()
+
+	s := &fakeAuthServer{challenges: map[string]string{}, scopes: map[string]string{}, pushed: map[string]url.Values{}, dpopTokens: map[string]string{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", s.token)
+	mux.HandleFunc("/par", func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+		if id, secret, _ := r.BasicAuth(); id != "client" || secret != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "invalid_client"})
+			return
+		}
+
+		requestURI := "urn:ietf:params:oauth:request_uri:" + r.PostForm.Get("state")
+		s.mu.Lock()
+		s.pushed[requestURI] = r.PostForm
+		s.mu.Unlock()
+
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"request_uri": requestURI, "expires_in": 60})
+	})
+	mux.HandleFunc("/device", func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"device_code":      "device-" + r.PostForm.Get("scope"),
+			"user_code":        "WDJB-MJHT",
+			"verification_url": s.URL + "/activate",
+			"expires_in":       60,
+			"interval":         1,
+		})
+	})
+	mux.HandleFunc("/userinfo", func(w http.ResponseWriter, r *http.Request) {
+		scheme, accessToken, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+
+		// DPoP-bound tokens are only accepted with a proof of their key
+		s.mu.Lock()
+		boundKey, bound := s.dpopTokens[accessToken]
+		s.mu.Unlock()
+
+		if bound {
+			key, _, err := checkDPoPProof(r.Header.Get("DPoP"), r.Method, s.URL+"/userinfo", accessToken)
+			if scheme != "DPoP" || err != nil || key != boundKey {
+				w.Header().Set("WWW-Authenticate", `DPoP error="invalid_token"`)
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+		} else if scheme != "Bearer" || accessToken == "" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		s.mu.Lock()
+		subject := s.subject
+		s.mu.Unlock()
+		if subject == "" {
+			subject = "42"
+		}
+		_ = json.NewEncoder(w).Encode(map[string]string{"sub": subject, "email": "jane@example.com"})
+	})
+
+	s.Server = httptest.NewServer(mux)
+	t.Cleanup(s.Close)
+	return s
+}
+
+// issueCode returns an authorization code bound to the PKCE challenge of an authorize URL.
+func (s *fakeAuthServer) issueCode(authURL string) string {
+	u, _ := url.Parse(authURL)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	code := "code-" + u.Query().Get("state")
+	s.challenges[code] = u.Query().Get("code_challenge")
+	s.scopes[code] = u.Query().Get("scope")
+	return code
+}
+
+func (s *fakeAuthServer) token(w http.ResponseWriter, r *http.Request) {
+	_ = r.ParseForm()
+
+	// DPoP proofs must carry the nonce of the server
+	dpopKey := ""
+	if proof := r.Header.Get("DPoP"); proof != "" {
+		key, claims, err := checkDPoPProof(proof, r.Method, s.URL+"/token", "")
+		if err != nil || stringClaim(claims, "nonce") != "token-nonce" {
+			w.Header().Set("DPoP-Nonce", "token-nonce")
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "use_dpop_nonce"})
+			return
+		}
+		dpopKey = key
+
+		s.mu.Lock()
+		s.dpopProofs = append(s.dpopProofs, proof)
+		s.mu.Unlock()
+	}
+
+	s.mu.Lock()
+	s.tokenForms = append(s.tokenForms, r.PostForm)
+	challenge, ok := s.challenges[r.PostForm.Get("code")]
+	scope := s.scopes[r.PostForm.Get("code")]
+	s.mu.Unlock()
+
+	switch r.PostForm.Get("grant_type") {
+	case "authorization_code":
+		if !ok || (challenge != "" && pkceChallenge(r.PostForm.Get("code_verifier")) != challenge) {
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+	case "refresh_token":
+	case tokenExchangeGrantType:
+		if r.PostForm.Get("subject_token") == "" || r.PostForm.Get("audience") == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "invalid_request"})
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token":      r.PostForm.Get("audience") + ":" + r.PostForm.Get("subject_token"),
+			"issued_token_type": accessTokenType,
+			"token_type":        "Bearer",
+			"expires_in":        3600,
+		})
+		return
+	case deviceGrantType:
+		s.mu.Lock()
+		s.devicePolls++
+		polls := s.devicePolls
+		s.mu.Unlock()
+
+		code := ""
+		switch {
+		case r.PostForm.Get("device_code") == "device-denied":
+			code = "access_denied"
+		case polls == 1:
+			code = "authorization_pending"
+		case polls == 2:
+			code = "slow_down"
+		}
+		if code != "" {
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": code})
+			return
+		}
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": "unsupported_grant_type"})
+		return
+	}
+
+	res := map[string]interface{}{
+		"access_token":  "at-" + r.PostForm.Get("code") + r.PostForm.Get("refresh_token"),
+		"refresh_token": "rt",
+		"token_type":    "Bearer",
+		"expires_in":    3600,
+	}
+	if scope != "" {
+		res["scope"] = scope
+	}
+	if dpopKey != "" {
+		res["token_type"] = "DPoP"
+
+		s.mu.Lock()
+		s.dpopTokens[res["access_token"].(string)] = dpopKey
+		s.mu.Unlock()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(res)
+}
+
+func (s *fakeAuthServer) lastTokenForm() url.Values {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.tokenForms) == 0 {
+		return nil
+	}
+	return s.tokenForms[len(s.tokenForms)-1]
+}
+
+// testOAuth2Provider is a goth provider talking to a fakeAuthServer.
+type testOAuth2Provider struct {
+	name       string
+	config     *oauth2.Config
+	HTTPClient *http.Client
+}
+
+func newTestOAuth2Provider(server *fakeAuthServer, name, redirectURL string, client *http.Client) *testOAuth2Provider {
+	return &testOAuth2Provider{
+		name: name,
+		config: &oauth2.Config{
+			ClientID:     "client",
+			ClientSecret: "secret",
+			RedirectURL:  redirectURL,
+			Endpoint: oauth2.Endpoint{
+				AuthURL:   server.URL + "/authorize",
+				TokenURL:  server.URL + "/token",
+				AuthStyle: oauth2.AuthStyleInParams,
+			},
+		},
+		HTTPClient: client,
+	}
+}
+
+type testOAuth2Session struct {
+	AuthURL      string
+	AccessToken  string
+	RefreshToken string
+	ExpiresAt    time.Time
+}
+
+func (p *testOAuth2Provider) Name() string        { return p.name }
+func (p *testOAuth2Provider) SetName(name string) { p.name = name }
+func (p *testOAuth2Provider) Debug(bool)          {}
+
+func (p *testOAuth2Provider) BeginAuth(state string) (goth.Session, error) {
+	return &testOAuth2Session{AuthURL: p.config.AuthCodeURL(state)}, nil
+}
+
+func (p *testOAuth2Provider) UnmarshalSession(data string) (goth.Session, error) {
+	sess := &testOAuth2Session{}
+	err := json.Unmarshal([]byte(data), sess)
+	return sess, err
+}
+
+func (p *testOAuth2Provider) FetchUser(session goth.Session) (goth.User, error) {
+	sess := session.(*testOAuth2Session)
+	user := goth.User{
+		Provider:     p.name,
+		AccessToken:  sess.AccessToken,
+		RefreshToken: sess.RefreshToken,
+		ExpiresAt:    sess.ExpiresAt,
+	}
+	if sess.AccessToken == "" {
+		return user, errors.New("no access token")
+	}
+
+	req, _ := http.NewRequest("GET", strings.Replace(p.config.Endpoint.TokenURL, "/token", "/userinfo", 1), nil)
+	req.Header.Set("Authorization", "Bearer "+sess.AccessToken)
+	resp, err := goth.HTTPClientWithFallBack(p.HTTPClient).Do(req)
+	if err != nil {
+		return user, err
+	}
+	defer resp.Body.Close()
+
+	var info map[string]string
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return user, err
+	}
+	user.UserID = info["sub"]
+	user.Email = info["email"]
+	return user, nil
+}
+
+func (p *testOAuth2Provider) RefreshTokenAvailable() bool { return true }
+
+func (p *testOAuth2Provider) RefreshToken(refreshToken string) (*oauth2.Token, error) {
+	ctx := goth.ContextForClient(goth.HTTPClientWithFallBack(p.HTTPClient))
+	return p.config.TokenSource(ctx, &oauth2.Token{RefreshToken: refreshToken}).Token()
+}
+
+func (s *testOAuth2Session) GetAuthURL() (string, error) { return s.AuthURL, nil }
+
+func (s *testOAuth2Session) Marshal() string {
+	b, _ := json.Marshal(s)
+	return string(b)
+}
+
+func (s *testOAuth2Session) Authorize(provider goth.Provider, params goth.Params) (string, error) {
+	p := provider.(*testOAuth2Provider)
+	ctx := goth.ContextForClient(goth.HTTPClientWithFallBack(p.HTTPClient))
+	token, err := p.config.Exchange(ctx, params.Get("code"))
+	if err != nil {
+		return "", err
+	}
+
+	s.AccessToken = token.AccessToken
+	s.RefreshToken = token.RefreshToken
+	s.ExpiresAt = token.Expiry
+	return token.AccessToken, nil
+}
@@ -0,0 +1,421 @@
+package goth_fiber
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/markbates/goth"
+	"golang.org/x/oauth2"
+)
+
+// Options that affect how RegisterOIDCProvider works.
+type OIDCProviderOptions struct {
+	// CallbackURL is the redirect URL registered with the identity provider.
+	// It is required.
+	CallbackURL string
+
+	// HTTPClient is used for discovery, the token endpoint, the userinfo
+	// endpoint and the JWKS.
+	//
+	// Defaults to ExchangeClient(nil), so ProviderOptions like PKCE apply.
+	HTTPClient *http.Client
+
+	// RefreshInterval is how long the discovery metadata is cached before
+	// it is fetched again.
+	//
+	// Defaults to 24 hours.
+	RefreshInterval time.Duration
+
+	// DiscoveryTimeout bounds fetching the discovery document.
+	//
+	// Defaults to 10 seconds.
+	DiscoveryTimeout time.Duration
+}
+
+// oidcMetadata is the part of the OpenID Provider Metadata used by this package.
+type oidcMetadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserinfoEndpoint      string `json:"userinfo_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+/*
+RegisterOIDCProvider registers a goth provider for an OpenID Connect identity
+provider, configured from the discovery document of issuerURL
+(.well-known/openid-configuration). The "openid" scope is always requested.
+
+The discovery document is fetched once before registering, so that
+misconfigurations are reported right away, and again once RefreshInterval
+has passed, without holding up requests that can use the cached metadata.
+When refreshing fails, the cached metadata keeps being used.
+ID tokens are verified with the keys of the jwks_uri of the metadata.
+*/
+func RegisterOIDCProvider(name, issuerURL, clientID, secret string, scopes []string, options ...OIDCProviderOptions) error {
+	var opts OIDCProviderOptions
+	if len(options) > 0 {
+		opts = options[0]
+	}
+
+	if opts.CallbackURL == "" {
+		return errors.New("goth_fiber: OIDCProviderOptions.CallbackURL is required")
+	}
+
+	if opts.HTTPClient == nil {
+		opts.HTTPClient = ExchangeClient(nil)
+	}
+
+	if opts.RefreshInterval == 0 {
+		opts.RefreshInterval = 24 * time.Hour
+	}
+
+	if opts.DiscoveryTimeout == 0 {
+		opts.DiscoveryTimeout = 10 * time.Second
+	}
+
+	hasOpenID := false
+	for _, scope := range scopes {
+		hasOpenID = hasOpenID || scope == "openid"
+	}
+	if !hasOpenID {
+		scopes = append([]string{"openid"}, scopes...)
+	}
+
+	p := &oidcProvider{
+		name:         name,
+		issuer:       strings.TrimSuffix(issuerURL, "/"),
+		clientID:     clientID,
+		clientSecret: secret,
+		callbackURL:  opts.CallbackURL,
+		scopes:       scopes,
+		client:       opts.HTTPClient,
+		refresh:      opts.RefreshInterval,
+		timeout:      opts.DiscoveryTimeout,
+	}
+
+	if _, err := p.metadata(); err != nil {
+		return err
+	}
+
+	goth.UseProviders(p)
+	return nil
+}
+
+// oidcProvider is a goth provider configured from OpenID Connect discovery.
+type oidcProvider struct {
+	name         string
+	issuer       string
+	clientID     string
+	clientSecret string
+	callbackURL  string
+	scopes       []string
+	client       *http.Client
+	refresh      time.Duration
+	timeout      time.Duration
+
+	mu       sync.Mutex
+	meta     *oidcMetadata
+	fetched  time.Time
+	jwks     *jwksCache
+	inflight *oidcDiscovery
+}
+
+// oidcDiscovery is a fetch of the discovery document, done is closed once it
+// completed.
+type oidcDiscovery struct {
+	done chan struct{}
+	meta *oidcMetadata
+	err  error
+}
+
+// metadata returns the discovery metadata, fetching it when it is missing or
+// stale. The document is fetched without holding the lock, once at a time:
+// while it is in flight, callers get the cached metadata, or wait for the
+// fetch when there is none.
+func (p *oidcProvider) metadata() (*oidcMetadata, error) {
+	p.mu.Lock()
+	if p.meta != nil && (time.Since(p.fetched) < p.refresh || p.inflight != nil) {
+		meta := p.meta
+		p.mu.Unlock()
+		return meta, nil
+	}
+
+	f := p.inflight
+	if f == nil {
+		f = &oidcDiscovery{done: make(chan struct{})}
+		p.inflight = f
+		p.mu.Unlock()
+
+		meta, err := p.discover()
+
+		p.mu.Lock()
+		switch {
+		case err == nil:
+			if p.jwks == nil || p.jwks.url != meta.JWKSURI {
+				p.jwks = newJWKSCache(meta.JWKSURI, p.client)
+			}
+			p.meta = meta
+			p.fetched = time.Now()
+		case p.meta != nil:
+			// keep using the cached metadata, try again later
+			meta, err = p.meta, nil
+			p.fetched = time.Now()
+		}
+		f.meta, f.err = meta, err
+		p.inflight = nil
+		p.mu.Unlock()
+		close(f.done)
+	} else {
+		p.mu.Unlock()
+		<-f.done
+	}
+
+	return f.meta, f.err
+}
+
+func (p *oidcProvider) discover() (*oidcMetadata, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.issuer+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("goth_fiber: fetching the discovery document of %s returned %s", p.issuer, resp.Status)
+	}
+
+	var meta oidcMetadata
+	if err := json.NewDecoder(resp.Body).Decode(&meta); err != nil {
+		return nil, err
+	}
+
+	if strings.TrimSuffix(meta.Issuer, "/") != p.issuer {
+		return nil, fmt.Errorf("goth_fiber: discovery document issuer %q does not match %q", meta.Issuer, p.issuer)
+	}
+
+	if meta.AuthorizationEndpoint == "" || meta.TokenEndpoint == "" || meta.JWKSURI == "" {
+		return nil, fmt.Errorf("goth_fiber: incomplete discovery document for %s", p.issuer)
+	}
+
+	return &meta, nil
+}
+
+func (p *oidcProvider) config() (*oauth2.Config, *oidcMetadata, error) {
+	meta, err := p.metadata()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &oauth2.Config{
+		ClientID:     p.clientID,
+		ClientSecret: p.clientSecret,
+		RedirectURL:  p.callbackURL,
+		Scopes:       p.scopes,
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  meta.AuthorizationEndpoint,
+			TokenURL: meta.TokenEndpoint,
+		},
+	}, meta, nil
+}
+
+func (p *oidcProvider) Name() string        { return p.name }
+func (p *oidcProvider) SetName(name string) { p.name = name }
+func (p *oidcProvider) Debug(bool)          {}
+
+func (p *oidcProvider) BeginAuth(state string) (goth.Session, error) {
+	config, _, err := p.config()
+	if err != nil {
+		return nil, err
+	}
+
+	return &oidcSession{AuthURL: config.AuthCodeURL(state)}, nil
+}
+
+func (p *oidcProvider) UnmarshalSession(data string) (goth.Session, error) {
+	sess := &oidcSession{}
+	err := json.Unmarshal([]byte(data), sess)
+	return sess, err
+}
+
+func (p *oidcProvider) FetchUser(session goth.Session) (goth.User, error) {
+	sess := session.(*oidcSession)
+	user := goth.User{
+		Provider:     p.name,
+		AccessToken:  sess.AccessToken,
+		RefreshToken: sess.RefreshToken,
+		ExpiresAt:    sess.ExpiresAt,
+		IDToken:      sess.IDToken,
+	}
+
+	if sess.AccessToken == "" || sess.IDToken == "" {
+		// not authorized yet, CompleteUserAuth authorizes and fetches again
+		return user, fmt.Errorf("%s cannot get user information without an access token and an ID token", p.name)
+	}
+
+	claims, err := p.verifyIDToken(sess.IDToken)
+	if err != nil {
+		return user, err
+	}
+
+	_, meta, err := p.config()
+	if err != nil {
+		return user, err
+	}
+
+	if meta.UserinfoEndpoint != "" {
+		info, err := p.userinfo(meta.UserinfoEndpoint, sess.AccessToken)
+		if err != nil {
+			return user, err
+		}
+
+		// the userinfo response is only trusted for the subject of the ID token
+		if info["sub"] == claims["sub"] {
+			for k, v := range info {
+				claims[k] = v
+			}
+		}
+	}
+
+	user.RawData = claims
+	user.UserID = stringClaim(claims, "sub")
+	user.Email = stringClaim(claims, "email")
+	user.Name = stringClaim(claims, "name")
+	user.FirstName = stringClaim(claims, "given_name")
+	user.LastName = stringClaim(claims, "family_name")
+	user.NickName = stringClaim(claims, "preferred_username")
+	user.AvatarURL = stringClaim(claims, "picture")
+	user.Location = stringClaim(claims, "locale")
+
+	return user, nil
+}
+
+// verifyIDToken checks the signature, issuer, audience and lifetime of an ID token.
+func (p *oidcProvider) verifyIDToken(idToken string) (map[string]interface{}, error) {
+	t, err := parseJWT(idToken)
+	if err != nil {
+		return nil, err
+	}
+
+	meta, err := p.metadata()
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	jwks := p.jwks
+	p.mu.Unlock()
+
+	key, err := jwks.key(t.header.Kid)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := t.verify(key); err != nil {
+		return nil, err
+	}
+
+	if err := t.validateClaims(meta.Issuer, p.clientID, time.Minute); err != nil {
+		return nil, err
+	}
+
+	return t.claims, nil
+}
+
+func (p *oidcProvider) userinfo(endpoint, accessToken string) (map[string]interface{}, error) {
+	req, err := http.NewRequest(http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("goth_fiber: userinfo endpoint returned %s", resp.Status)
+	}
+
+	var info map[string]interface{}
+	err = json.NewDecoder(resp.Body).Decode(&info)
+	return info, err
+}
+
+func (p *oidcProvider) RefreshTokenAvailable() bool { return true }
+
+func (p *oidcProvider) RefreshToken(refreshToken string) (*oauth2.Token, error) {
+	config, _, err := p.config()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, p.client)
+	return config.TokenSource(ctx, &oauth2.Token{RefreshToken: refreshToken}).Token()
+}
+
+// oidcSession is the goth session of an oidcProvider.
+type oidcSession struct {
+	AuthURL      string
+	AccessToken  string
+	RefreshToken string
+	ExpiresAt    time.Time
+	IDToken      string
+}
+
+func (s *oidcSession) GetAuthURL() (string, error) {
+	if s.AuthURL == "" {
+		return "", errors.New(goth.NoAuthUrlErrorMessage)
+	}
+	return s.AuthURL, nil
+}
+
+func (s *oidcSession) Marshal() string {
+	b, _ := json.Marshal(s)
+	return string(b)
+}
+
+func (s *oidcSession) Authorize(provider goth.Provider, params goth.Params) (string, error) {
+	p := provider.(*oidcProvider)
+	config, _, err := p.config()
+	if err != nil {
+		return "", err
+	}
+
+	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, p.client)
+	token, err := config.Exchange(ctx, params.Get("code"))
+	if err != nil {
+		return "", err
+	}
+
+	if !token.Valid() {
+		return "", errors.New("invalid token received from provider")
+	}
+
+	idToken, _ := token.Extra("id_token").(string)
+	if idToken == "" {
+		return "", errors.New("goth_fiber: token response without id_token")
+	}
+
+	s.AccessToken = token.AccessToken
+	s.RefreshToken = token.RefreshToken
+	s.ExpiresAt = token.Expiry
+	s.IDToken = idToken
+	return token.AccessToken, nil
+}
@@ -0,0 +1,270 @@
+package goth_fiber
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/markbates/goth"
+)
+
+// newDiscoveryServer starts an OpenID provider serving discovery, token,
+// userinfo and JWKS endpoints, with ID tokens signed by a fresh key.
+func newDiscoveryServer(t *testing.T, discoveries *int32) *httptest.Server {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var server *httptest.Server
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(discoveries, 1)
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 server.URL,
+			"authorization_endpoint": server.URL + "/authorize",
+			"token_endpoint":         server.URL + "/token",
+			"userinfo_endpoint":      server.URL + "/userinfo",
+			"jwks_uri":               server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		jwk, _ := publicJWK(&key.PublicKey)
+		jwk.Kid = "key-1"
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"keys": []jsonWebKey{jwk}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+		if r.PostForm.Get("code") != "good-code" {
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+
+		idToken, _ := signJWT(jwtHeader{Alg: "ES256", Kid: "key-1"}, map[string]interface{}{
+			"iss":  server.URL,
+			"aud":  "client",
+			"sub":  "42",
+			"name": "Jane Doe",
+			"iat":  time.Now().Unix(),
+			"exp":  time.Now().Add(time.Hour).Unix(),
+		}, key)
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": "at",
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+			"id_token":     idToken,
+		})
+	})
+	mux.HandleFunc("/userinfo", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer at" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]string{"sub": "42", "email": "jane@example.com"})
+	})
+
+	server = httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func Test_RegisterOIDCProvider(t *testing.T) {
+	var discoveries int32
+	server := newDiscoveryServer(t, &discoveries)
+
+	goth.ClearProviders()
+	err := RegisterOIDCProvider("oidc", server.URL, "client", "secret", []string{"email"}, OIDCProviderOptions{
+		CallbackURL: "http://localhost/callback/oidc",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := fiber.New()
+	app.Get("/auth/:provider", BeginAuthHandler)
+	app.Get("/callback/:provider", func(c fiber.Ctx) error {
+		user, err := CompleteUserAuth(c)
+		if err != nil {
+			return c.Status(ErrorStatus(err)).SendString(err.Error())
+		}
+		return c.SendString(user.UserID + " " + user.Name + " " + user.Email)
+	})
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/auth/oidc", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(location.String(), server.URL+"/authorize?") || location.Query().Get("scope") != "openid email" {
+		t.Fatalf("unexpected authorization URL %s", location)
+	}
+
+	req := httptest.NewRequest("GET", "/callback/oidc?code=good-code&state="+url.QueryEscape(location.Query().Get("state")), nil)
+	for _, cookie := range resp.Cookies() {
+		req.AddCookie(cookie)
+	}
+	resp, err = app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != "42 Jane Doe jane@example.com" {
+		t.Errorf("unexpected user %q", body)
+	}
+
+	if n := atomic.LoadInt32(&discoveries); n != 1 {
+		t.Errorf("expected the discovery document to be cached, fetched %d times", n)
+	}
+}
+
+func Test_RegisterOIDCProvider_RefreshesMetadata(t *testing.T) {
+	var discoveries int32
+	server := newDiscoveryServer(t, &discoveries)
+
+	goth.ClearProviders()
+	err := RegisterOIDCProvider("oidc", server.URL, "client", "secret", nil, OIDCProviderOptions{
+		CallbackURL:     "http://localhost/callback/oidc",
+		RefreshInterval: time.Nanosecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	provider, err := goth.GetProvider("oidc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := provider.BeginAuth("state"); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := atomic.LoadInt32(&discoveries); n != 2 {
+		t.Errorf("expected stale metadata to be fetched again, fetched %d times", n)
+	}
+}
+
+func Test_RegisterOIDCProvider_DiscoveryFailure(t *testing.T) {
+	var discoveries int32
+	server := newDiscoveryServer(t, &discoveries)
+
+	goth.ClearProviders()
+	err := RegisterOIDCProvider("oidc", server.URL+"/other", "client", "secret", nil, OIDCProviderOptions{
+		CallbackURL: "http://localhost/callback/oidc",
+	})
+	if err == nil {
+		t.Fatal("expected an error for a missing discovery document")
+	}
+
+	if _, err := goth.GetProvider("oidc"); err == nil {
+		t.Error("expected the provider not to be registered")
+	}
+}
+
+func Test_OIDCProvider_ConcurrentDiscovery(t *testing.T) {
+	t.Parallel()
+
+	var discoveries int32
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&discoveries, 1)
+		started <- struct{}{}
+		<-release
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 server.URL,
+			"authorization_endpoint": server.URL + "/authorize",
+			"token_endpoint":         server.URL + "/token",
+			"jwks_uri":               server.URL + "/jwks",
+		})
+	}))
+	defer server.Close()
+
+	p := &oidcProvider{issuer: server.URL, client: http.DefaultClient, refresh: time.Hour, timeout: 5 * time.Second}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for n := 0; n < 10; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := p.metadata()
+			errs <- err
+		}()
+	}
+
+	<-started
+	close(release)
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Errorf("expected the discovered metadata, got %v", err)
+		}
+	}
+	if n := atomic.LoadInt32(&discoveries); n != 1 {
+		t.Errorf("expected a single discovery, fetched %d times", n)
+	}
+
+	// stale metadata is served while it is being fetched again
+	release = make(chan struct{})
+	p.mu.Lock()
+	p.fetched = time.Time{}
+	p.mu.Unlock()
+
+	go func() { _, _ = p.metadata() }()
+	<-started
+
+	cached := make(chan error, 1)
+	go func() {
+		_, err := p.metadata()
+		cached <- err
+	}()
+	select {
+	case err := <-cached:
+		if err != nil {
+			t.Errorf("expected the cached metadata during the fetch, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("expected the cached metadata not to wait for the fetch")
+	}
+	close(release)
+}
+
+func Test_OIDCProvider_DiscoveryTimeout(t *testing.T) {
+	t.Parallel()
+
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	p := &oidcProvider{issuer: server.URL, client: http.DefaultClient, refresh: time.Hour, timeout: 50 * time.Millisecond}
+	if _, err := p.metadata(); err == nil {
+		t.Error("expected the discovery to time out")
+	}
+}
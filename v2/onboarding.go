@@ -0,0 +1,259 @@
+package goth_fiber
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/markbates/goth"
+)
+
+// gateSessionKeyPrefix prefixes the session keys holding the value a gate
+// was completed with, followed by the owner of the gate, see gateSessionKey.
+const gateSessionKeyPrefix = "_goth_gate_"
+
+// ErrOnboardingRequired is returned by RequireOnboarding for requests of
+// users that did not complete all the gates.
+var ErrOnboardingRequired = errors.New("goth_fiber: onboarding required")
+
+/*
+Gate is a step users complete once before using the application, such as
+accepting the terms or completing their profile. It is completed with
+CompleteGate, and recorded under Name in the session and, when the store
+supports it, in the Attributes of the account.
+*/
+type Gate struct {
+	// Name identifies the gate.
+	Name string
+
+	// Version is the value the gate must be completed with, e.g. the version
+	// of the terms: changing it sends users through the gate again. Empty
+	// means any completion counts.
+	Version string
+
+	// URL is the page completing the gate, where RequireOnboarding sends
+	// users and which it lets through, along with the paths under it.
+	URL string
+}
+
+// TermsGate returns a gate requiring version of the terms, accepted at url.
+func TermsGate(version, url string) Gate {
+	return Gate{Name: "terms", Version: version, URL: url}
+}
+
+// ProfileGate returns a gate requiring the profile to be completed at url.
+func ProfileGate(url string) Gate {
+	return Gate{Name: "profile", URL: url}
+}
+
+/*
+AccountUpdater is implemented by UserStores that can record gates in the
+Attributes of accounts, so that they stay completed across sessions.
+MemoryUserStore implements it.
+*/
+type AccountUpdater interface {
+	// FindByID returns the account with the given ID.
+	FindByID(ctx context.Context, id string) (*Account, error)
+
+	// SetAttribute sets an attribute of an account.
+	SetAttribute(ctx context.Context, accountID, key, value string) error
+}
+
+// Options that affect how RequireOnboarding works.
+type OnboardingOptions struct {
+	// Gates to complete, in order.
+	Gates []Gate
+
+	// Store is the UserStore of AccountCallbackHandler. When it implements
+	// AccountUpdater, gates are recorded in the account too.
+	Store UserStore
+
+	// Allow lists paths let through before the gates are completed, along
+	// with the paths under them, e.g. the logout route or "/static".
+	Allow []string
+
+	// ErrorHandler is called for requests that cannot be redirected to a
+	// gate, with ErrOnboardingRequired, and when reading the gates fails.
+	//
+	// Defaults to responding with ErrorStatus and the error message.
+	ErrorHandler func(ctx fiber.Ctx, err error) error
+}
+
+/*
+RequireOnboarding returns a middleware intercepting the requests of session
+users until they completed all the gates. GET requests are redirected to the
+URL of the first gate to complete, with the current URL as return-to, see
+GetReturnTo. Other requests get 403 Forbidden with ErrOnboardingRequired.
+
+Requests without a session user are let through, to be handled by the
+authentication middlewares.
+*/
+func RequireOnboarding(options ...OnboardingOptions) fiber.Handler {
+	var opts OnboardingOptions
+	if len(options) > 0 {
+		opts = options[0]
+	}
+
+	if opts.ErrorHandler == nil {
+		opts.ErrorHandler = func(ctx fiber.Ctx, err error) error {
+			return ctx.Status(ErrorStatus(err)).SendString(err.Error())
+		}
+	}
+
+	return func(ctx fiber.Ctx) error {
+		user, err := GetUserFromSession(ctx)
+		if err != nil {
+			return ctx.Next()
+		}
+
+		path := ctx.Path()
+		for _, allowed := range opts.Allow {
+			if pathUnder(path, allowed) {
+				return ctx.Next()
+			}
+		}
+
+		gate, err := nextGate(ctx, opts, user)
+		if err != nil {
+			return opts.ErrorHandler(ctx, err)
+		}
+		if gate == nil || pathUnder(path, gate.URL) {
+			return ctx.Next()
+		}
+
+		if ctx.Method() != fiber.MethodGet && ctx.Method() != fiber.MethodHead {
+			return opts.ErrorHandler(ctx, ErrOnboardingRequired)
+		}
+
+		if err := StoreInSession(returnToSessionKey, ctx.OriginalURL(), ctx); err != nil {
+			return err
+		}
+
+		return ctx.Redirect().To(gate.URL)
+	}
+}
+
+// nextGate returns the first gate user, the session user, did not complete,
+// or nil. Gates recorded in the account only are copied to the session, so
+// the store is not read again.
+func nextGate(ctx fiber.Ctx, opts OnboardingOptions, user goth.User) (*Gate, error) {
+	var account *Account
+	loaded := false
+
+	for i, gate := range opts.Gates {
+		key := gateSessionKey(ctx, user, gate)
+		value, _ := GetFromSession(key, ctx)
+		if gateCompleted(gate, value) {
+			continue
+		}
+
+		if !loaded {
+			loaded = true
+
+			var err error
+			if account, err = sessionAccount(ctx, opts.Store); err != nil {
+				return nil, err
+			}
+		}
+
+		if account != nil && gateCompleted(gate, account.Attributes[gate.Name]) {
+			if err := StoreInSession(key, account.Attributes[gate.Name], ctx); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		return &opts.Gates[i], nil
+	}
+
+	return nil, nil
+}
+
+// sessionAccount returns the account the session is logged in to, or nil
+// when store cannot look it up.
+func sessionAccount(ctx fiber.Ctx, store UserStore) (*Account, error) {
+	updater, ok := store.(AccountUpdater)
+	if !ok {
+		return nil, nil
+	}
+
+	id, err := GetAccountID(ctx)
+	if err != nil {
+		return nil, nil
+	}
+
+	account, err := updater.FindByID(ctx.Context(), id)
+	if errors.Is(err, ErrAccountNotFound) {
+		return nil, nil
+	}
+	return account, err
+}
+
+// gateSessionKey returns the session key of gate for user, the session user.
+// It holds the account the session is logged in to, or the identity of user,
+// so that gates completed by one user are not taken for another's.
+func gateSessionKey(ctx fiber.Ctx, user goth.User, gate Gate) string {
+	owner, err := GetAccountID(ctx)
+	if err != nil {
+		owner = user.Provider + ":" + user.UserID
+	}
+	return gateSessionKeyPrefix + owner + ":" + gate.Name
+}
+
+// pathUnder reports whether path is base or a path under it.
+func pathUnder(path, base string) bool {
+	if base == "" {
+		return false
+	}
+	if path == base {
+		return true
+	}
+	if !strings.HasSuffix(base, "/") {
+		base += "/"
+	}
+	return strings.HasPrefix(path, base)
+}
+
+func gateCompleted(gate Gate, value string) bool {
+	if gate.Version != "" {
+		return value == gate.Version
+	}
+	return value != ""
+}
+
+/*
+CompleteGate records that the session user completed gate, in the session
+and, when store implements AccountUpdater and the session is logged in to an
+account, in its Attributes. store may be nil. Without a session user, it
+returns an error wrapping ErrNotAuthenticated.
+
+It is meant to be called by the page of the gate, which then usually
+redirects to GetReturnTo.
+*/
+func CompleteGate(ctx fiber.Ctx, gate Gate, store UserStore) error {
+	user, err := GetUserFromSession(ctx)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrNotAuthenticated, err)
+	}
+
+	if err := takeReturnTo(ctx); err != nil {
+		return err
+	}
+
+	value := gate.Version
+	if value == "" {
+		value = "completed"
+	}
+
+	if updater, ok := store.(AccountUpdater); ok {
+		if id, err := GetAccountID(ctx); err == nil {
+			if err := updater.SetAttribute(ctx.Context(), id, gate.Name, value); err != nil {
+				return err
+			}
+		}
+	}
+
+	return StoreInSession(gateSessionKey(ctx, user, gate), value, ctx)
+}
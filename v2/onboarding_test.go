@@ -0,0 +1,231 @@
+package goth_fiber
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/markbates/goth"
+	"github.com/markbates/goth/providers/faux"
+)
+
+func Test_RequireOnboarding(t *testing.T) {
+	goth.ClearProviders()
+	goth.UseProviders(&faux.Provider{})
+
+	store := NewMemoryUserStore()
+	terms := TermsGate("v1", "/terms")
+	profile := ProfileGate("/profile")
+
+	newApp := func(gates ...Gate) *fiber.App {
+		app := fiber.New()
+		app.Get("/auth/:provider", BeginAuthHandler)
+		app.Get("/callback/:provider", AccountCallbackHandler(AccountOptions{Store: store, OnboardingURL: terms.URL}))
+		app.Use(RequireOnboarding(OnboardingOptions{Gates: gates, Store: store, Allow: []string{"/logout"}}))
+		for _, gate := range gates {
+			gate := gate
+			app.Get(gate.URL, func(c fiber.Ctx) error { return c.SendString(gate.Name) })
+			app.Post(gate.URL, func(c fiber.Ctx) error {
+				if err := CompleteGate(c, gate, store); err != nil {
+					return err
+				}
+				return c.Redirect().To(GetReturnTo(c))
+			})
+		}
+		app.Get("/logout", func(c fiber.Ctx) error { return c.SendString("logout") })
+		app.All("/dashboard", func(c fiber.Ctx) error { return c.SendString("dashboard") })
+		return app
+	}
+
+	var cookies []*http.Cookie
+	do := func(app *fiber.App, method, path string) *http.Response {
+		t.Helper()
+
+		req := httptest.NewRequest(method, path, nil)
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(resp.Cookies()) > 0 {
+			cookies = resp.Cookies()
+		}
+		return resp
+	}
+	login := func(app *fiber.App) *http.Response {
+		t.Helper()
+
+		cookies = nil
+		do(app, "GET", "/auth/faux?state=test-state")
+		return do(app, "GET", "/callback/faux?code=test-code&state=test-state")
+	}
+
+	app := newApp(terms, profile)
+
+	if resp := login(app); resp.Header.Get("Location") != "/terms" {
+		t.Fatalf("expected the sign-up to be sent to onboarding, got %d %s", resp.StatusCode, resp.Header.Get("Location"))
+	}
+
+	if resp := do(app, "GET", "/dashboard"); resp.Header.Get("Location") != "/terms" {
+		t.Errorf("expected the terms to be required, got %d %s", resp.StatusCode, resp.Header.Get("Location"))
+	}
+	if resp := do(app, "POST", "/dashboard"); resp.StatusCode != fiber.StatusForbidden {
+		t.Errorf("expected other requests to be refused, got %d", resp.StatusCode)
+	}
+	if resp := do(app, "GET", "/logout"); resp.StatusCode != fiber.StatusOK {
+		t.Errorf("expected allowed paths to be let through, got %d", resp.StatusCode)
+	}
+	if resp := do(app, "GET", "/terms"); resp.StatusCode != fiber.StatusOK {
+		t.Errorf("expected the gate page to be let through, got %d", resp.StatusCode)
+	}
+
+	do(app, "GET", "/dashboard")
+	if resp := do(app, "POST", "/terms"); resp.Header.Get("Location") != "/dashboard" {
+		t.Errorf("expected the gate to return to the dashboard, got %s", resp.Header.Get("Location"))
+	}
+	if resp := do(app, "GET", "/dashboard"); resp.Header.Get("Location") != "/profile" {
+		t.Errorf("expected the profile to be required next, got %d %s", resp.StatusCode, resp.Header.Get("Location"))
+	}
+	do(app, "POST", "/profile")
+	if resp := do(app, "GET", "/dashboard"); resp.StatusCode != fiber.StatusOK {
+		t.Errorf("expected the onboarded user to be let through, got %d", resp.StatusCode)
+	}
+
+	// gates stay completed in the account
+	if resp := login(app); resp.Header.Get("Location") != "/" {
+		t.Errorf("expected the sign-in not to be sent to onboarding, got %s", resp.Header.Get("Location"))
+	}
+	if resp := do(app, "GET", "/dashboard"); resp.StatusCode != fiber.StatusOK {
+		t.Errorf("expected the gates to be read from the account, got %d %s", resp.StatusCode, resp.Header.Get("Location"))
+	}
+
+	app = newApp(TermsGate("v2", "/terms"), profile)
+	if resp := do(app, "GET", "/dashboard"); resp.Header.Get("Location") != "/terms" {
+		t.Errorf("expected new terms to be accepted again, got %d %s", resp.StatusCode, resp.Header.Get("Location"))
+	}
+}
+
+func Test_RequireOnboarding_Anonymous(t *testing.T) {
+	t.Parallel()
+
+	app := fiber.New()
+	app.Use(RequireOnboarding(OnboardingOptions{Gates: []Gate{TermsGate("v1", "/terms")}}))
+	app.Get("/", func(c fiber.Ctx) error { return c.SendString("home") })
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != fiber.StatusOK {
+		t.Errorf("expected requests without a session user to be let through, got %d", resp.StatusCode)
+	}
+}
+
+func Test_RequireOnboarding_SignUpReturnTo(t *testing.T) {
+	goth.ClearProviders()
+	goth.UseProviders(&faux.Provider{})
+
+	store := NewMemoryUserStore()
+	terms := TermsGate("v1", "/terms")
+
+	app := fiber.New()
+	app.Get("/auth/:provider", BeginAuthHandler)
+	app.Get("/callback/:provider", AccountCallbackHandler(AccountOptions{Store: store, OnboardingURL: terms.URL}))
+	app.Use(RequireOnboarding(OnboardingOptions{Gates: []Gate{terms}, Store: store}))
+	app.Post(terms.URL, func(c fiber.Ctx) error {
+		if err := CompleteGate(c, terms, store); err != nil {
+			return err
+		}
+		return c.SendString(GetReturnTo(c))
+	})
+	app.Get("/return-to", func(c fiber.Ctx) error { return c.SendString(GetReturnTo(c)) })
+
+	var cookies []*http.Cookie
+	do := func(method, path string) string {
+		t.Helper()
+
+		req := httptest.NewRequest(method, path, nil)
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(resp.Cookies()) > 0 {
+			cookies = resp.Cookies()
+		}
+		body, _ := io.ReadAll(resp.Body)
+		return string(body)
+	}
+
+	do("GET", "/auth/faux?state=test-state&rd=%2Fsettings")
+	do("GET", "/callback/faux?code=test-code&state=test-state")
+
+	if got := do("POST", "/terms"); got != "/settings" {
+		t.Errorf("expected the onboarding to go on to the return-to of the sign-up, got %q", got)
+	}
+	if got := do("GET", "/return-to"); got != "" {
+		t.Errorf("expected the return-to to be cleared once the gate completed, got %q", got)
+	}
+}
+
+func Test_RequireOnboarding_PerUserAndPaths(t *testing.T) {
+	terms := TermsGate("v1", "/terms")
+
+	app := fiber.New()
+	app.Get("/login/:user", func(c fiber.Ctx) error {
+		return StoreUserInSession(goth.User{Provider: "faux", UserID: c.Params("user")}, c)
+	})
+	app.Use(RequireOnboarding(OnboardingOptions{Gates: []Gate{terms}, Allow: []string{"/static"}}))
+	app.Post("/terms", func(c fiber.Ctx) error { return CompleteGate(c, terms, nil) })
+	app.Get("/*", func(c fiber.Ctx) error { return c.SendString("ok") })
+
+	var cookies []*http.Cookie
+	do := func(method, path string) *http.Response {
+		t.Helper()
+
+		req := httptest.NewRequest(method, path, nil)
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(resp.Cookies()) > 0 {
+			cookies = resp.Cookies()
+		}
+		return resp
+	}
+
+	do("GET", "/login/1")
+
+	for path, allowed := range map[string]bool{
+		"/static":         true,
+		"/static/app.js":  true,
+		"/staticfoo":      false,
+		"/terms/full":     true,
+		"/dashboard":      false,
+		"/dashboard/tabs": false,
+	} {
+		if resp := do("GET", path); (resp.StatusCode == fiber.StatusOK) != allowed {
+			t.Errorf("%s: expected allowed %v, got %d", path, allowed, resp.StatusCode)
+		}
+	}
+
+	do("POST", "/terms")
+	if resp := do("GET", "/dashboard"); resp.StatusCode != fiber.StatusOK {
+		t.Errorf("expected the onboarded user to be let through, got %d", resp.StatusCode)
+	}
+
+	// another user logging in to the same session completes its own gates
+	do("GET", "/login/2")
+	if resp := do("GET", "/dashboard"); resp.Header.Get("Location") != "/terms" {
+		t.Errorf("expected the gates of the previous user not to count, got %d %s", resp.StatusCode, resp.Header.Get("Location"))
+	}
+}
@@ -0,0 +1,96 @@
+package goth_fiber
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+
+	"github.com/gofiber/fiber/v3"
+)
+
+// parSessionKey prefixes the session key holding the pushed authorization response of a provider.
+const parSessionKey = "_goth_par_"
+
+// ErrPushedAuthorization is returned by GetAuthURL when the pushed
+// authorization request fails. OAuth errors of the endpoint are wrapped as a
+// *ProviderError too.
+var ErrPushedAuthorization = errors.New("goth_fiber: pushed authorization request failed")
+
+// PushedAuthorizationResponse is the response of a pushed authorization
+// request endpoint, kept in the session for the duration of the flow.
+type PushedAuthorizationResponse struct {
+	RequestURI string `json:"request_uri"`
+	ExpiresIn  int64  `json:"expires_in"`
+}
+
+/*
+pushAuthorizationRequest posts the parameters of authURL to the pushed
+authorization request endpoint (RFC 9126) and returns the URL of the
+authorization endpoint with only the client_id and the request_uri.
+*/
+func pushAuthorizationRequest(ctx fiber.Ctx, providerName, authURL string, opts ProviderOptions) (string, error) {
+	u, err := url.Parse(authURL)
+	if err != nil {
+		return "", err
+	}
+
+	params := u.Query()
+	clientID := opts.ClientID
+	if clientID == "" {
+		clientID = params.Get("client_id")
+	}
+	params.Set("client_id", clientID)
+
+	audience := opts.TokenURL
+	if audience == "" {
+		audience = opts.PushedAuthorizationURL
+	}
+	clientSecret, err := authenticateClient(params, opts, clientID, audience)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrPushedAuthorization, err)
+	}
+
+	var par PushedAuthorizationResponse
+	err = postForm(ctx.Context(), nil, opts.PushedAuthorizationURL, params, clientID, clientSecret, &par)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrPushedAuthorization, err)
+	}
+
+	if par.RequestURI == "" {
+		return "", fmt.Errorf("%w: response without request_uri", ErrPushedAuthorization)
+	}
+
+	b, err := json.Marshal(par)
+	if err != nil {
+		return "", err
+	}
+
+	if err := StoreInSession(parSessionKey+providerName, string(b), ctx); err != nil {
+		return "", err
+	}
+
+	u.RawQuery = url.Values{"client_id": {clientID}, "request_uri": {par.RequestURI}}.Encode()
+	return u.String(), nil
+}
+
+// GetPushedAuthorization returns the pushed authorization response stored by
+// GetAuthURL for the provider of the request.
+func GetPushedAuthorization(ctx fiber.Ctx) (*PushedAuthorizationResponse, error) {
+	providerName, err := GetProviderName(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	value, err := GetFromSession(parSessionKey+providerName, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var par PushedAuthorizationResponse
+	if err := json.Unmarshal([]byte(value), &par); err != nil {
+		return nil, err
+	}
+
+	return &par, nil
+}
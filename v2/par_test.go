@@ -0,0 +1,97 @@
+package goth_fiber
+
+import (
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/markbates/goth"
+)
+
+func Test_GetAuthURL_PushedAuthorization(t *testing.T) {
+	server := newFakeAuthServer(t)
+
+	goth.ClearProviders()
+	goth.UseProviders(newTestOAuth2Provider(server, "par", "http://localhost/callback/par", nil))
+	SetProviderOptions("par", ProviderOptions{
+		PKCE:                   true,
+		PushedAuthorizationURL: server.URL + "/par",
+		ClientSecret:           "secret",
+	})
+	defer SetProviderOptions("par", ProviderOptions{})
+
+	app := fiber.New()
+	app.Get("/auth/:provider", BeginAuthHandler)
+	app.Get("/par/:provider", func(c fiber.Ctx) error {
+		par, err := GetPushedAuthorization(c)
+		if err != nil {
+			return c.Status(404).SendString(err.Error())
+		}
+		return c.SendString(par.RequestURI)
+	})
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/auth/par?state=test-state", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	query := location.Query()
+	if len(query) != 2 || query.Get("client_id") != "client" || query.Get("request_uri") == "" {
+		t.Fatalf("expected only client_id and request_uri, got %s", location.RawQuery)
+	}
+
+	pushed := server.pushed[query.Get("request_uri")]
+	if pushed.Get("state") != "test-state" || pushed.Get("code_challenge") == "" || pushed.Get("redirect_uri") == "" {
+		t.Errorf("expected the authorization parameters to be pushed, got %v", pushed)
+	}
+
+	req := httptest.NewRequest("GET", "/par/par", nil)
+	for _, cookie := range resp.Cookies() {
+		req.AddCookie(cookie)
+	}
+	resp, err = app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != query.Get("request_uri") {
+		t.Errorf("expected the pushed authorization response in the session, got %s", string(body))
+	}
+}
+
+func Test_GetAuthURL_PushedAuthorizationError(t *testing.T) {
+	server := newFakeAuthServer(t)
+
+	goth.ClearProviders()
+	goth.UseProviders(newTestOAuth2Provider(server, "par", "http://localhost/callback/par", nil))
+	SetProviderOptions("par", ProviderOptions{
+		PushedAuthorizationURL: server.URL + "/par",
+		ClientSecret:           "wrong",
+	})
+	defer SetProviderOptions("par", ProviderOptions{})
+
+	app := fiber.New()
+	app.Get("/auth/:provider", AuthURLHandler())
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/auth/par", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var body map[string]string
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if body["error"] != "invalid_client" {
+		t.Errorf("expected the PAR error to be surfaced, got %d %v", resp.StatusCode, body)
+	}
+}
@@ -0,0 +1,224 @@
+package goth_fiber
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+
+	"github.com/gofiber/fiber/v3"
+	"golang.org/x/oauth2"
+)
+
+// pkceSessionKey prefixes the session key holding the PKCE code verifier of a provider.
+const pkceSessionKey = "_goth_pkce_"
+
+// pkceVerifiers maps authorization codes being exchanged to their PKCE code
+// verifier, for exchangeTransport to add to the token request.
+var pkceVerifiers sync.Map
+
+// GetContextWithPKCE marks the request so that GetAuthURL uses PKCE (RFC 7636)
+// with the S256 method, see also ProviderOptions.PKCE. The provider must use
+// ExchangeClient as its HTTP client for the code verifier to reach the token
+// endpoint.
+func GetContextWithPKCE(ctx fiber.Ctx) fiber.Ctx {
+	ctx.Locals(pkceKey, true)
+	return ctx
+}
+
+func usesPKCE(ctx fiber.Ctx) bool {
+	enabled, _ := ctx.Locals(pkceKey).(bool)
+	return enabled
+}
+
+// applyPKCE adds a fresh S256 code challenge to authURL and keeps its code
+// verifier in the session until CompleteUserAuth.
+func applyPKCE(ctx fiber.Ctx, providerName, authURL string) (string, error) {
+	verifier, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+
+	authURL, err = addQueryParams(authURL, url.Values{
+		"code_challenge":        {pkceChallenge(verifier)},
+		"code_challenge_method": {"S256"},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if err := StoreInSession(pkceSessionKey+providerName, verifier, ctx); err != nil {
+		return "", err
+	}
+
+	return authURL, nil
+}
+
+// takePKCEVerifier returns the PKCE code verifier kept for providerName, if
+// any, and removes it from the session so that no later exchange reuses it.
+func takePKCEVerifier(ctx fiber.Ctx, providerName string) (string, error) {
+	verifier, err := GetFromSession(pkceSessionKey+providerName, ctx)
+	if err != nil || verifier == "" {
+		return "", nil
+	}
+
+	return verifier, StoreInSession(pkceSessionKey+providerName, "", ctx)
+}
+
+// pkceChallenge returns the S256 code challenge of a code verifier.
+func pkceChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// addQueryParams sets params on the query of rawURL.
+func addQueryParams(rawURL string, params url.Values) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+
+	q := u.Query()
+	for k, v := range params {
+		q[k] = v
+	}
+	u.RawQuery = q.Encode()
+
+	return u.String(), nil
+}
+
+/*
+ExchangeClient returns an *http.Client to set as the HTTP client of goth
+providers, e.g. google.New(...).HTTPClient. It lets this package amend the
+requests the provider makes to its token endpoint, such as adding the PKCE
+code verifier to the code exchange. Requests are sent with base, or
+http.DefaultClient when base is nil.
+*/
+func ExchangeClient(base *http.Client) *http.Client {
+	if base == nil {
+		base = http.DefaultClient
+	}
+
+	client := *base
+	client.Transport = &exchangeTransport{base: base.Transport}
+	return &client
+}
+
+// exchangeTransport amends token endpoint requests before sending them with base.
+type exchangeTransport struct {
+	base http.RoundTripper
+}
+
+func (t *exchangeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	form, ok := tokenRequestForm(req)
+	if !ok {
+		// such as the user info request made with a DPoP-bound token
+		if accessToken, key := requestDPoPKey(req); key != nil {
+			token := &oauth2.Token{AccessToken: accessToken, TokenType: "DPoP"}
+			return (&dpopTransport{base: base, source: oauth2.StaticTokenSource(token), key: key}).RoundTrip(req)
+		}
+		return base.RoundTrip(req)
+	}
+
+	if form.Get("grant_type") == "authorization_code" && form.Get("code_verifier") == "" {
+		if verifier, ok := pkceVerifiers.Load(form.Get("code")); ok {
+			form.Set("code_verifier", verifier.(string))
+		}
+	}
+
+	header := req.Header.Clone()
+	if opts, ok := tokenRequestProviderOptions(form); ok && opts.ClientAssertionKey != nil && opts.TokenURL == endpointURL(req.URL) {
+		clientID := opts.ClientID
+		if clientID == "" {
+			clientID = form.Get("client_id")
+		}
+		if user, _, ok := req.BasicAuth(); ok && clientID == "" {
+			clientID, _ = url.QueryUnescape(user)
+		}
+
+		if err := setClientAssertion(form, opts, clientID, opts.TokenURL); err != nil {
+			return nil, err
+		}
+		header.Del("Authorization")
+	}
+
+	// send a copy with the amended body, the caller's request stays as it was
+	body := form.Encode()
+	clone := req.Clone(req.Context())
+	clone.Header = header
+	clone.Body = io.NopCloser(bytes.NewBufferString(body))
+	clone.ContentLength = int64(len(body))
+	clone.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	clone.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewBufferString(body)), nil
+	}
+
+	var resp *http.Response
+	var err error
+	if key := tokenRequestDPoPKey(form); key != nil {
+		resp, err = sendWithDPoP(base, clone, key, "")
+	} else {
+		resp, err = base.RoundTrip(clone)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := captureTokenResponse(form, resp); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+// tokenRequestForm returns the form of req if it looks like an OAuth2 token request.
+func tokenRequestForm(req *http.Request) (url.Values, bool) {
+	if req.Method != http.MethodPost || req.Body == nil || req.Header.Get("Content-Type") != "application/x-www-form-urlencoded" {
+		return nil, false
+	}
+
+	b, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	req.Body = io.NopCloser(bytes.NewReader(b))
+	if err != nil {
+		return nil, false
+	}
+
+	form, err := url.ParseQuery(string(b))
+	if err != nil || form.Get("grant_type") == "" {
+		return nil, false
+	}
+
+	return form, true
+}
+
+// tokenRequestID returns the code or refresh token a token request form is
+// exchanging, which the registries of exchangeTransport are keyed by.
+func tokenRequestID(form url.Values) string {
+	switch form.Get("grant_type") {
+	case "authorization_code":
+		return form.Get("code")
+	case "refresh_token":
+		return form.Get("refresh_token")
+	default:
+		return ""
+	}
+}
+
+// endpointURL returns u without its query and fragment.
+func endpointURL(u *url.URL) string {
+	endpoint := *u
+	endpoint.RawQuery = ""
+	endpoint.Fragment = ""
+	return endpoint.String()
+}
@@ -0,0 +1,65 @@
+package goth_fiber
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/markbates/goth"
+)
+
+func Test_PKCE_NotReused(t *testing.T) {
+	server := newFakeAuthServer(t)
+
+	goth.ClearProviders()
+	goth.UseProviders(newTestOAuth2Provider(server, "pkce", "http://localhost/callback/pkce", ExchangeClient(nil)))
+
+	app := fiber.New()
+	app.Get("/auth/:provider", BeginAuthHandler)
+	app.Get("/pkce/:provider", func(c fiber.Ctx) error {
+		return BeginAuthHandler(GetContextWithPKCE(c))
+	})
+	app.Get("/callback/:provider", func(c fiber.Ctx) error {
+		_, err := CompleteUserAuth(c, CompleteUserAuthOptions{ShouldLogout: false})
+		return err
+	})
+
+	var cookies []*http.Cookie
+	get := func(path string) *http.Response {
+		t.Helper()
+
+		req := httptest.NewRequest("GET", path, nil)
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c := resp.Cookies(); len(c) > 0 {
+			cookies = c
+		}
+		return resp
+	}
+
+	// a PKCE flow that is abandoned, then a plain one
+	get("/pkce/pkce")
+	authURL := get("/auth/pkce").Header.Get("Location")
+
+	location, _ := url.Parse(authURL)
+	resp := get("/callback/pkce?" + url.Values{
+		"code":  {server.issueCode(authURL)},
+		"state": {location.Query().Get("state")},
+	}.Encode())
+	if resp.StatusCode != fiber.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		t.Fatalf("unexpected status %d: %s", resp.StatusCode, body)
+	}
+
+	if verifier := server.lastTokenForm().Get("code_verifier"); verifier != "" {
+		t.Errorf("expected no code verifier in the plain code exchange, got %q", verifier)
+	}
+}
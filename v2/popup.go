@@ -0,0 +1,160 @@
+package goth_fiber
+
+import (
+	"bytes"
+	"html/template"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/markbates/goth"
+)
+
+// PopupMessageType is the "type" of the message posted to the opener window.
+const PopupMessageType = "goth_fiber:auth"
+
+// Options that affect how the popup handlers work.
+type PopupOptions struct {
+	// Origin of the opener window the result is posted to, e.g.
+	// "https://app.example.com". It is required, the result is never posted
+	// to an arbitrary origin.
+	Origin string
+
+	// Summary returns the user data posted to the opener on success.
+	//
+	// Defaults to the provider, user id, name, email and avatar URL. Tokens
+	// are never included unless Summary adds them.
+	Summary func(user goth.User) map[string]interface{}
+
+	// KeepSession keeps the session once the authentication is completed,
+	// see CompleteUserAuthOptions.
+	//
+	// Defaults to false.
+	KeepSession bool
+}
+
+// popupMessage is posted to the opener window.
+type popupMessage struct {
+	Type  string                 `json:"type"`
+	OK    bool                   `json:"ok"`
+	User  map[string]interface{} `json:"user,omitempty"`
+	Error *popupError            `json:"error,omitempty"`
+}
+
+type popupError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+var popupTemplate = template.Must(template.New("popup").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>Signing in</title></head>
+<body><script nonce="{{.Nonce}}">
+(function () {
+  if (window.opener) {
+    window.opener.postMessage({{.Message}}, {{.Origin}});
+  }
+  window.close();
+})();
+</script></body></html>
+`))
+
+/*
+PopupBeginHandler returns a variant of BeginAuthHandler for logins running in
+a popup window. It redirects to the provider like BeginAuthHandler, but errors
+are delivered to the opener window like PopupCallbackHandler does, so the popup
+does not get stuck on an error page.
+*/
+func PopupBeginHandler(options ...PopupOptions) fiber.Handler {
+	opts := popupOptions(options)
+
+	return func(ctx fiber.Ctx) error {
+		url, err := GetAuthURL(ctx)
+		if err != nil {
+			return renderPopup(ctx, opts, goth.User{}, err)
+		}
+
+		return ctx.Redirect().Status(fiber.StatusTemporaryRedirect).To(url)
+	}
+}
+
+/*
+PopupCallbackHandler returns a callback handler for logins running in a popup
+window. It completes the authentication and renders a minimal page that posts
+the outcome to window.opener, restricted to the configured origin, then closes
+the popup.
+
+The message is {type, ok, user} on success and {type, ok, error: {code,
+message}} on failure, where code is given by ErrorCode and message is the
+error_description of JSONErrorHandler.
+*/
+func PopupCallbackHandler(options ...PopupOptions) fiber.Handler {
+	opts := popupOptions(options)
+
+	return func(ctx fiber.Ctx) error {
+		user, err := CompleteUserAuth(ctx, CompleteUserAuthOptions{ShouldLogout: !opts.KeepSession})
+		return renderPopup(ctx, opts, user, err)
+	}
+}
+
+func popupOptions(options []PopupOptions) PopupOptions {
+	var opts PopupOptions
+	if len(options) > 0 {
+		opts = options[0]
+	}
+
+	if opts.Origin == "" {
+		panic("goth_fiber: PopupOptions.Origin is required")
+	}
+
+	if opts.Summary == nil {
+		opts.Summary = userSummary
+	}
+
+	return opts
+}
+
+// userSummary is the user data safe to hand to a browser: no tokens, no raw data.
+func userSummary(user goth.User) map[string]interface{} {
+	return map[string]interface{}{
+		"provider":   user.Provider,
+		"user_id":    user.UserID,
+		"name":       user.Name,
+		"email":      user.Email,
+		"avatar_url": user.AvatarURL,
+	}
+}
+
+func renderPopup(ctx fiber.Ctx, opts PopupOptions, user goth.User, err error) error {
+	msg := popupMessage{Type: PopupMessageType, OK: err == nil}
+	if err != nil {
+		// the opener is a browser, so the message is kept free of internal details
+		msg.Error = &popupError{Code: ErrorCode(err), Message: errorDescription(err)}
+	} else {
+		msg.User = opts.Summary(user)
+	}
+
+	nonce, nerr := randomToken()
+	if nerr != nil {
+		return nerr
+	}
+
+	var b bytes.Buffer
+	nerr = popupTemplate.Execute(&b, map[string]interface{}{
+		"Nonce":   nonce,
+		"Message": msg,
+		"Origin":  opts.Origin,
+	})
+	if nerr != nil {
+		return nerr
+	}
+
+	ctx.Set(fiber.HeaderContentSecurityPolicy, "default-src 'none'; script-src 'nonce-"+nonce+"'; base-uri 'none'; form-action 'none'; frame-ancestors 'none'")
+	ctx.Set(fiber.HeaderCacheControl, "no-store")
+	ctx.Set(fiber.HeaderReferrerPolicy, "no-referrer")
+	ctx.Set(fiber.HeaderXContentTypeOptions, "nosniff")
+	ctx.Type("html", "utf-8")
+
+	if err != nil {
+		ctx.Status(fiber.StatusBadRequest)
+	}
+
+	return ctx.Send(b.Bytes())
+}
@@ -0,0 +1,136 @@
+package goth_fiber
+
+import (
+	"errors"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/markbates/goth"
+	"github.com/markbates/goth/providers/faux"
+)
+
+func Test_PopupCallbackHandler(t *testing.T) {
+	goth.ClearProviders()
+	goth.UseProviders(&faux.Provider{})
+
+	opts := PopupOptions{Origin: "https://app.example.com"}
+
+	app := fiber.New()
+	app.Get("/auth/:provider", PopupBeginHandler(opts))
+	app.Get("/callback/:provider", PopupCallbackHandler(opts))
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/auth/faux?state=test-state", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != fiber.StatusTemporaryRedirect {
+		t.Fatalf("expected redirect, got %d", resp.StatusCode)
+	}
+	cookies := resp.Cookies()
+
+	req := httptest.NewRequest("GET", "/callback/faux?code=test-code&state=test-state", nil)
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+	resp, err = app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, _ := io.ReadAll(resp.Body)
+	page := string(body)
+
+	csp := resp.Header.Get("Content-Security-Policy")
+	if !strings.Contains(csp, "script-src 'nonce-") {
+		t.Errorf("expected a nonce based CSP, got '%s'", csp)
+	}
+	if !strings.Contains(page, `"https://app.example.com"`) {
+		t.Error("expected the message to be posted to the configured origin")
+	}
+	if !strings.Contains(page, `"ok":true`) || !strings.Contains(page, `"provider":"faux"`) {
+		t.Errorf("expected a success message, got: %s", page)
+	}
+	if strings.Contains(page, "access") {
+		t.Error("expected no token in the page")
+	}
+}
+
+func Test_PopupCallbackHandler_ProviderError(t *testing.T) {
+	goth.ClearProviders()
+	goth.UseProviders(&faux.Provider{})
+
+	opts := PopupOptions{Origin: "https://app.example.com"}
+
+	app := fiber.New()
+	app.Get("/auth/:provider", PopupBeginHandler(opts))
+	app.Get("/callback/:provider", PopupCallbackHandler(opts))
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/auth/faux?state=test-state", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookies := resp.Cookies()
+
+	req := httptest.NewRequest("GET", "/callback/faux?error=access_denied&state=test-state", nil)
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+	resp, err = app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, _ := io.ReadAll(resp.Body)
+	if !strings.Contains(string(body), `"code":"access_denied"`) {
+		t.Errorf("expected the provider error code in the message, got: %s", string(body))
+	}
+}
+
+func Test_PopupCallbackHandler_InternalError(t *testing.T) {
+	goth.ClearProviders()
+	goth.UseProviders(&faux.Provider{})
+	AdmissionPolicies = []AdmissionPolicy{func(ctx fiber.Ctx, user goth.User) error {
+		return errors.New("lookup 10.0.0.7: connection refused")
+	}}
+	defer func() { AdmissionPolicies = nil }()
+
+	opts := PopupOptions{Origin: "https://app.example.com"}
+
+	app := fiber.New()
+	app.Get("/auth/:provider", PopupBeginHandler(opts))
+	app.Get("/callback/:provider", PopupCallbackHandler(opts))
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/auth/faux?state=test-state", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest("GET", "/callback/faux?code=test-code&state=test-state", nil)
+	for _, cookie := range resp.Cookies() {
+		req.AddCookie(cookie)
+	}
+	resp, err = app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, _ := io.ReadAll(resp.Body)
+	if strings.Contains(string(body), "10.0.0.7") || !strings.Contains(string(body), `"message":"internal error"`) {
+		t.Errorf("expected a generic message for an internal error, got: %s", string(body))
+	}
+}
+
+func Test_PopupOptions_RequireOrigin(t *testing.T) {
+	t.Parallel()
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected a panic without an origin")
+		}
+	}()
+
+	PopupCallbackHandler()
+}
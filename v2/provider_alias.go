@@ -0,0 +1,63 @@
+package goth_fiber
+
+import (
+	"sync"
+
+	"github.com/markbates/goth"
+)
+
+var (
+	providerAliasesMu sync.RWMutex
+	providerAliases   = map[string]goth.Provider{}
+)
+
+/*
+RegisterProviderAlias makes provider available under alias, for running
+several configurations of the same provider type side by side, e.g. two Okta
+orgs as "okta-eu" and "okta-us". provider must be an instance of its own,
+with its own credentials and callback URL, as it is renamed to alias.
+
+The alias is used in place of the provider name everywhere in this package:
+it is resolved by GetAuthURL and CompleteUserAuth before Resolver and goth's
+registry, keys the session values and ProviderOptions, and is reported as
+the Provider of the returned user.
+*/
+func RegisterProviderAlias(alias string, provider goth.Provider) {
+	provider.SetName(alias)
+
+	providerAliasesMu.Lock()
+	defer providerAliasesMu.Unlock()
+
+	providerAliases[alias] = provider
+}
+
+// RemoveProviderAlias removes an alias registered with RegisterProviderAlias.
+func RemoveProviderAlias(alias string) {
+	providerAliasesMu.Lock()
+	defer providerAliasesMu.Unlock()
+
+	delete(providerAliases, alias)
+}
+
+func aliasedProvider(alias string) (goth.Provider, bool) {
+	providerAliasesMu.RLock()
+	defer providerAliasesMu.RUnlock()
+
+	provider, ok := providerAliases[alias]
+	return provider, ok
+}
+
+// addAliases adds the aliased providers to providers, keyed by alias.
+func addAliases(providers goth.Providers) goth.Providers {
+	providerAliasesMu.RLock()
+	defer providerAliasesMu.RUnlock()
+
+	all := goth.Providers{}
+	for name, provider := range providers {
+		all[name] = provider
+	}
+	for alias, provider := range providerAliases {
+		all[alias] = provider
+	}
+	return all
+}
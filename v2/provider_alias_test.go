@@ -0,0 +1,68 @@
+package goth_fiber
+
+import (
+	"io"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/markbates/goth"
+)
+
+func Test_RegisterProviderAlias(t *testing.T) {
+	eu := newFakeAuthServer(t)
+	us := newFakeAuthServer(t)
+
+	goth.ClearProviders()
+	RegisterProviderAlias("okta-eu", newTestOAuth2Provider(eu, "okta", "http://localhost/callback/okta-eu", nil))
+	RegisterProviderAlias("okta-us", newTestOAuth2Provider(us, "okta", "http://localhost/callback/okta-us", nil))
+	defer RemoveProviderAlias("okta-eu")
+	defer RemoveProviderAlias("okta-us")
+
+	app := fiber.New()
+	app.Get("/auth/:provider", BeginAuthHandler)
+	app.Get("/callback/:provider", func(c fiber.Ctx) error {
+		user, err := CompleteUserAuth(c, CompleteUserAuthOptions{ShouldLogout: false})
+		if err != nil {
+			return c.Status(ErrorStatus(err)).SendString(err.Error())
+		}
+
+		if _, err := TokenSource(c); err != nil {
+			return err
+		}
+		return c.SendString(user.Provider)
+	})
+
+	for alias, server := range map[string]*fakeAuthServer{"okta-eu": eu, "okta-us": us} {
+		resp, err := app.Test(httptest.NewRequest("GET", "/auth/"+alias, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		authURL := resp.Header.Get("Location")
+		if !strings.HasPrefix(authURL, server.URL+"/authorize?") {
+			t.Fatalf("expected the %s instance to be used, got %s", alias, authURL)
+		}
+
+		location, _ := url.Parse(authURL)
+		req := httptest.NewRequest("GET", "/callback/"+alias+"?"+url.Values{
+			"code":  {server.issueCode(authURL)},
+			"state": {location.Query().Get("state")},
+		}.Encode(), nil)
+		for _, cookie := range resp.Cookies() {
+			req.AddCookie(cookie)
+		}
+
+		resp, err = app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		body, _ := io.ReadAll(resp.Body)
+		if string(body) != alias {
+			t.Errorf("expected the user to report the alias %s, got %q", alias, body)
+		}
+	}
+}
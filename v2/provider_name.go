@@ -0,0 +1,79 @@
+package goth_fiber
+
+import (
+	"fmt"
+
+	"github.com/gofiber/fiber/v3"
+)
+
+// ProviderNameExtractor returns the provider name found in a request, or ""
+// when there is none.
+type ProviderNameExtractor func(ctx fiber.Ctx) string
+
+// ProviderNameExtractors are tried in order by GetProviderName, the first
+// name found is used. A name set with GetContextWithProvider takes precedence
+// over all of them.
+var ProviderNameExtractors = []ProviderNameExtractor{
+	ProviderFromQuery("provider"),
+	ProviderFromParam("provider"),
+	ProviderFromHeader("provider"),
+}
+
+// ProviderNameSessionFallback makes GetProviderName fall back to the first
+// provider the session holds an authentication for, when no extractor finds
+// a name.
+var ProviderNameSessionFallback = true
+
+// AllowedProviders restricts the provider names GetProviderName accepts.
+// When empty, all names are accepted.
+var AllowedProviders []string
+
+// ProviderFromQuery extracts the provider name from a query parameter.
+func ProviderFromQuery(key string) ProviderNameExtractor {
+	return func(ctx fiber.Ctx) string {
+		return ctx.Query(key)
+	}
+}
+
+// ProviderFromParam extracts the provider name from a route parameter.
+func ProviderFromParam(key string) ProviderNameExtractor {
+	return func(ctx fiber.Ctx) string {
+		return ctx.Params(key)
+	}
+}
+
+// ProviderFromHeader extracts the provider name from a request header.
+func ProviderFromHeader(key string) ProviderNameExtractor {
+	return func(ctx fiber.Ctx) string {
+		return ctx.Get(key)
+	}
+}
+
+// ProviderFromCookie extracts the provider name from a cookie.
+func ProviderFromCookie(name string) ProviderNameExtractor {
+	return func(ctx fiber.Ctx) string {
+		return ctx.Cookies(name)
+	}
+}
+
+// ProviderFromSubdomain extracts the provider name from the first label of
+// the request host, e.g. "okta" for okta.login.example.com.
+func ProviderFromSubdomain() ProviderNameExtractor {
+	return ProviderNameExtractor(TenantFromSubdomain)
+}
+
+// checkAllowedProvider returns an error wrapping ErrUnknownProvider when name
+// is not in AllowedProviders.
+func checkAllowedProvider(name string) error {
+	if len(AllowedProviders) == 0 {
+		return nil
+	}
+
+	for _, allowed := range AllowedProviders {
+		if name == allowed {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%w: %s is not allowed", ErrUnknownProvider, name)
+}
@@ -0,0 +1,140 @@
+package goth_fiber
+
+import (
+	"errors"
+	"io"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/markbates/goth"
+	"github.com/markbates/goth/providers/faux"
+)
+
+func Test_GetProviderName_Extractors(t *testing.T) {
+	defaults := ProviderNameExtractors
+	defer func() { ProviderNameExtractors = defaults }()
+
+	ProviderNameExtractors = []ProviderNameExtractor{
+		ProviderFromCookie("idp"),
+		ProviderFromSubdomain(),
+		func(ctx fiber.Ctx) string { return ctx.Get("X-Custom") },
+	}
+
+	app := fiber.New()
+	app.Get("/", func(c fiber.Ctx) error {
+		name, err := GetProviderName(c)
+		if err != nil {
+			return c.Status(ErrorStatus(err)).SendString(ErrorCode(err))
+		}
+		return c.SendString(name)
+	})
+
+	tests := []struct {
+		name   string
+		cookie string
+		host   string
+		header string
+		want   string
+	}{
+		{name: "cookie first", cookie: "okta", host: "azure.example.com", want: "okta"},
+		{name: "subdomain", host: "azure.example.com", header: "github", want: "azure"},
+		{name: "custom", host: "localhost", header: "github", want: "github"},
+		{name: "query ignored", host: "localhost", want: "no_provider"},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/?provider=faux", nil)
+		req.Host = tt.host
+		if tt.cookie != "" {
+			req.Header.Set("Cookie", "idp="+tt.cookie)
+		}
+		if tt.header != "" {
+			req.Header.Set("X-Custom", tt.header)
+		}
+
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		body, _ := io.ReadAll(resp.Body)
+		if got := string(body); got != tt.want {
+			t.Errorf("%s: expected %q, got %q", tt.name, tt.want, got)
+		}
+	}
+}
+
+func Test_GetProviderName_AllowedProviders(t *testing.T) {
+	AllowedProviders = []string{"faux"}
+	defer func() { AllowedProviders = nil }()
+
+	app := fiber.New()
+	app.Get("/", func(c fiber.Ctx) error {
+		if name, err := GetProviderName(c); err != nil || name != "faux" {
+			t.Errorf("expected faux to be allowed, got %q %v", name, err)
+		}
+
+		c.Request().URI().QueryArgs().Set("provider", "evil")
+		if _, err := GetProviderName(c); !errors.Is(err, ErrUnknownProvider) {
+			t.Errorf("expected ErrUnknownProvider, got %v", err)
+		}
+		return nil
+	})
+
+	if _, err := app.Test(httptest.NewRequest("GET", "/?provider=faux", nil)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_GetProviderName_WithoutSessionFallback(t *testing.T) {
+	goth.ClearProviders()
+	goth.UseProviders(&faux.Provider{})
+
+	ProviderNameSessionFallback = false
+	defer func() { ProviderNameSessionFallback = true }()
+
+	app := fiber.New()
+	app.Get("/", func(c fiber.Ctx) error {
+		if err := StoreInSession("faux", "{}", c); err != nil {
+			return err
+		}
+
+		if _, err := GetProviderName(c); !errors.Is(err, ErrNoProvider) {
+			t.Errorf("expected ErrNoProvider, got %v", err)
+		}
+		return nil
+	})
+
+	if _, err := app.Test(httptest.NewRequest("GET", "/", nil)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_GetProviderName_ContextProviderFirst(t *testing.T) {
+	defaults := ProviderNameExtractors
+	defer func() { ProviderNameExtractors = defaults }()
+
+	app := fiber.New()
+	app.Get("/", func(c fiber.Ctx) error {
+		name, err := GetProviderName(GetContextWithProvider(c, "github"))
+		if err != nil {
+			return err
+		}
+		return c.SendString(name)
+	})
+
+	for _, extractors := range [][]ProviderNameExtractor{defaults, {ProviderFromQuery("idp")}} {
+		ProviderNameExtractors = extractors
+
+		resp, err := app.Test(httptest.NewRequest("GET", "/?provider=faux&idp=faux", nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		body, _ := io.ReadAll(resp.Body)
+		if string(body) != "github" {
+			t.Errorf("expected the provider of the context to win over the request, got %s", body)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package goth_fiber
+
+import (
+	"crypto"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// ProviderOptions configures how this package runs the authentication flow
+// of a provider, on top of what the goth provider itself does.
+type ProviderOptions struct {
+	// PKCE makes GetAuthURL use PKCE (RFC 7636) for every request of the
+	// provider, see GetContextWithPKCE.
+	PKCE bool
+
+	// PushedAuthorizationURL is the pushed authorization request endpoint
+	// (RFC 9126). When set, GetAuthURL pushes the authorization parameters to
+	// it and returns an auth URL holding only the client_id and request_uri.
+	PushedAuthorizationURL string
+
+	// ClientID and ClientSecret authenticate requests this package makes to
+	// the provider's endpoints itself. ClientID defaults to the client_id of
+	// the auth URL.
+	ClientID     string
+	ClientSecret string
+
+	// TokenURL is the token endpoint of the provider. ExchangeClient only
+	// sends client assertions to it.
+	TokenURL string
+
+	// ClientAssertionKey enables private_key_jwt client authentication
+	// (RFC 7523) instead of the client secret, for the token requests sent
+	// through ExchangeClient and the requests this package makes itself.
+	// It is an *rsa.PrivateKey, *ecdsa.PrivateKey or ed25519.PrivateKey, and
+	// TokenURL must be set.
+	ClientAssertionKey crypto.Signer
+
+	// ClientAssertionKeyID is set as the "kid" header of client assertions.
+	ClientAssertionKeyID string
+
+	// ClientAssertionAlgorithm signs client assertions.
+	//
+	// Defaults to RS256 for RSA keys, ES256/ES384/ES512 for ECDSA keys and
+	// EdDSA for Ed25519 keys.
+	ClientAssertionAlgorithm string
+
+	// ClientAssertionLifetime is how long client assertions are valid for.
+	//
+	// Defaults to 1 minute.
+	ClientAssertionLifetime time.Duration
+
+	// DPoP binds the tokens of the provider to a key generated for each
+	// session (RFC 9449). The key is kept in the session, so the session
+	// storage must be server side. Token and user info requests carry DPoP
+	// proofs when the provider uses ExchangeClient, and HTTPClient sends
+	// proofs along with the access token.
+	DPoP bool
+
+	// AuthParams are added to the authorization URL built by GetAuthURL,
+	// e.g. prompt or hd.
+	AuthParams url.Values
+
+	// PassthroughParams are the names of the query parameters of the begin
+	// request copied to the authorization URL, overriding AuthParams, e.g.
+	// login_hint or ui_locales. Parameters of the OAuth2 flow itself, such as
+	// redirect_uri or state, are never copied.
+	PassthroughParams []string
+
+	// AllowedScopes are the scopes the begin request may add to the scopes
+	// of the provider, in its ScopeParam query parameter.
+	AllowedScopes []string
+
+	// IncrementalAuthorization asks the provider to include the scopes
+	// granted before in the new tokens (include_granted_scopes), and keeps
+	// them in the scopes recorded for the session.
+	IncrementalAuthorization bool
+
+	// ClaimsMapper finds the roles and groups of the users of the provider,
+	// stored in their RawData by CompleteUserAuth.
+	//
+	// Defaults to DefaultClaimsMapper.
+	ClaimsMapper ClaimsMapper
+
+	// AdmissionPolicies are evaluated by CompleteUserAuth for the users of
+	// the provider, after the global AdmissionPolicies.
+	AdmissionPolicies []AdmissionPolicy
+}
+
+var (
+	providerOptionsMu sync.RWMutex
+	providerOptions   = map[string]ProviderOptions{}
+)
+
+// SetProviderOptions sets the options of the provider with the given name.
+func SetProviderOptions(name string, opts ProviderOptions) {
+	providerOptionsMu.Lock()
+	defer providerOptionsMu.Unlock()
+
+	providerOptions[name] = opts
+}
+
+// GetProviderOptions returns the options of the provider with the given name,
+// the zero value if none have been set.
+func GetProviderOptions(name string) ProviderOptions {
+	providerOptionsMu.RLock()
+	defer providerOptionsMu.RUnlock()
+
+	return providerOptions[name]
+}
+
+// tokenRequestOptions maps the codes and refresh tokens being exchanged to
+// the options of the provider they were issued by, for exchangeTransport to
+// authenticate the token request with.
+var tokenRequestOptions sync.Map
+
+// tokenRequestProviderOptions returns the options registered for the code or
+// refresh token of a token request form.
+func tokenRequestProviderOptions(form url.Values) (ProviderOptions, bool) {
+	opts, ok := tokenRequestOptions.Load(tokenRequestID(form))
+	if !ok {
+		return ProviderOptions{}, false
+	}
+	return opts.(ProviderOptions), true
+}
@@ -0,0 +1,433 @@
+package goth_fiber
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/markbates/goth"
+)
+
+/*
+ProviderResolver returns the goth providers to use for a request, for
+instance the providers of the tenant the request belongs to. When Resolver is
+set, it is consulted instead of goth's global provider registry by
+GetAuthURL, CompleteUserAuth, the GetProviderName session fallback and the
+helpers building on them.
+
+Users stored in the session are bound to the tenant of the request they
+logged in with: GetUserFromSession rejects them on requests of other tenants
+with ErrTenantMismatch.
+*/
+type ProviderResolver interface {
+	// GetProvider returns the provider with the given name for the request,
+	// or an error wrapping ErrUnknownProvider when there is none.
+	GetProvider(ctx fiber.Ctx, name string) (goth.Provider, error)
+
+	// GetProviders returns all the providers available to the request.
+	GetProviders(ctx fiber.Ctx) (goth.Providers, error)
+
+	// GetProviderOptions returns the options of the provider with the given
+	// name for the request, and false when the provider has none of its own
+	// and the ones of SetProviderOptions apply.
+	GetProviderOptions(ctx fiber.Ctx, name string) (ProviderOptions, bool)
+
+	// Tenant returns the tenant the request belongs to, "" when unknown.
+	Tenant(ctx fiber.Ctx) string
+}
+
+// Resolver resolves the providers of requests. When nil, goth's global
+// provider registry is used.
+var Resolver ProviderResolver
+
+// ErrTenantMismatch is returned by GetUserFromSession when the session user
+// logged in through another tenant than the one of the request.
+var ErrTenantMismatch = errors.New("goth_fiber: session belongs to another tenant")
+
+// TenantFunc returns the tenant a request belongs to, or "" when unknown.
+type TenantFunc func(ctx fiber.Ctx) string
+
+// TenantFromSubdomain returns the first label of the request host, e.g.
+// "acme" for acme.app.example.com.
+func TenantFromSubdomain(ctx fiber.Ctx) string {
+	host := ctx.Hostname()
+	if i := strings.IndexByte(host, '.'); i > 0 {
+		return host[:i]
+	}
+	return ""
+}
+
+// TenantFromHeader returns a TenantFunc reading the tenant from a request
+// header. Only use it behind a proxy that sets the header.
+func TenantFromHeader(header string) TenantFunc {
+	return func(ctx fiber.Ctx) string {
+		return ctx.Get(header)
+	}
+}
+
+// requestTenant returns the tenant of the request, "" without Resolver.
+func requestTenant(ctx fiber.Ctx) string {
+	if Resolver == nil {
+		return ""
+	}
+	return Resolver.Tenant(ctx)
+}
+
+// requestProviderOptions returns the options of a provider for the request, the
+// ones Resolver has for its tenant first.
+func requestProviderOptions(ctx fiber.Ctx, name string) ProviderOptions {
+	if Resolver != nil {
+		if opts, ok := Resolver.GetProviderOptions(ctx, name); ok {
+			return opts
+		}
+	}
+	return GetProviderOptions(name)
+}
+
+// availableProviders returns the providers available to the request.
+func availableProviders(ctx fiber.Ctx) (goth.Providers, error) {
+	if Resolver == nil {
+		return goth.GetProviders(), nil
+	}
+	return Resolver.GetProviders(ctx)
+}
+
+// MemoryResolver is a ProviderResolver holding the providers of each tenant in memory.
+type MemoryResolver struct {
+	tenant TenantFunc
+
+	mu        sync.RWMutex
+	providers map[string]goth.Providers
+	options   map[string]map[string]ProviderOptions
+}
+
+// NewMemoryResolver returns an empty MemoryResolver finding the tenant of requests with tenant.
+func NewMemoryResolver(tenant TenantFunc) *MemoryResolver {
+	return &MemoryResolver{
+		tenant:    tenant,
+		providers: map[string]goth.Providers{},
+		options:   map[string]map[string]ProviderOptions{},
+	}
+}
+
+// Use adds providers to a tenant, replacing the ones with the same names.
+func (r *MemoryResolver) Use(tenant string, providers ...goth.Provider) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.providers[tenant] == nil {
+		r.providers[tenant] = goth.Providers{}
+	}
+	for _, provider := range providers {
+		r.providers[tenant][provider.Name()] = provider
+	}
+}
+
+// SetOptions sets the options of a provider of a tenant, which take
+// precedence over the ones of SetProviderOptions.
+func (r *MemoryResolver) SetOptions(tenant, name string, opts ProviderOptions) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.options[tenant] == nil {
+		r.options[tenant] = map[string]ProviderOptions{}
+	}
+	r.options[tenant][name] = opts
+}
+
+// Remove removes a provider, and its options, from a tenant.
+func (r *MemoryResolver) Remove(tenant, name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.providers[tenant], name)
+	delete(r.options[tenant], name)
+}
+
+// GetProvider implements ProviderResolver.
+func (r *MemoryResolver) GetProvider(ctx fiber.Ctx, name string) (goth.Provider, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	provider, ok := r.providers[r.tenant(ctx)][name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownProvider, name)
+	}
+	return provider, nil
+}
+
+// GetProviders implements ProviderResolver.
+func (r *MemoryResolver) GetProviders(ctx fiber.Ctx) (goth.Providers, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	providers := goth.Providers{}
+	for name, provider := range r.providers[r.tenant(ctx)] {
+		providers[name] = provider
+	}
+	return providers, nil
+}
+
+// GetProviderOptions implements ProviderResolver.
+func (r *MemoryResolver) GetProviderOptions(ctx fiber.Ctx, name string) (ProviderOptions, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	opts, ok := r.options[r.tenant(ctx)][name]
+	return opts, ok
+}
+
+// Tenant implements ProviderResolver.
+func (r *MemoryResolver) Tenant(ctx fiber.Ctx) string {
+	return r.tenant(ctx)
+}
+
+// TenantProviderConfig is the configuration of a provider of a tenant, as
+// kept by a StorageResolver.
+type TenantProviderConfig struct {
+	Tenant       string            `json:"tenant"`
+	Name         string            `json:"name"`
+	ClientID     string            `json:"client_id"`
+	ClientSecret string            `json:"client_secret"`
+	CallbackURL  string            `json:"callback_url"`
+	Scopes       []string          `json:"scopes,omitempty"`
+	Params       map[string]string `json:"params,omitempty"`
+}
+
+// Options that affect how a StorageResolver works.
+type StorageResolverOptions struct {
+	// CacheTTL is how long providers are kept once built, before their
+	// configuration is read from the storage again. Changes made through
+	// another instance of the application are seen after at most CacheTTL.
+	//
+	// Defaults to 1 minute.
+	CacheTTL time.Duration
+
+	// Options builds the options of a provider from its configuration. When
+	// nil, the ones of SetProviderOptions apply to every tenant.
+	Options func(config TenantProviderConfig) ProviderOptions
+}
+
+/*
+StorageResolver is a ProviderResolver keeping the provider configurations of
+each tenant in a fiber.Storage, so that they can be shared by several
+instances of the application and changed at runtime. Providers are built
+from their configuration with the build function given to NewStorageResolver.
+
+As client secrets are kept in the storage, it must be protected accordingly.
+*/
+type StorageResolver struct {
+	storage fiber.Storage
+	tenant  TenantFunc
+	build   func(config TenantProviderConfig) (goth.Provider, error)
+	options func(config TenantProviderConfig) ProviderOptions
+	ttl     time.Duration
+
+	mu    sync.Mutex
+	cache map[string]cachedProvider
+}
+
+type cachedProvider struct {
+	provider goth.Provider
+	options  *ProviderOptions
+	expires  time.Time
+}
+
+// NewStorageResolver returns a StorageResolver over storage.
+func NewStorageResolver(storage fiber.Storage, tenant TenantFunc, build func(config TenantProviderConfig) (goth.Provider, error), options ...StorageResolverOptions) *StorageResolver {
+	var opts StorageResolverOptions
+	if len(options) > 0 {
+		opts = options[0]
+	}
+
+	if opts.CacheTTL == 0 {
+		opts.CacheTTL = time.Minute
+	}
+
+	return &StorageResolver{
+		storage: storage,
+		tenant:  tenant,
+		build:   build,
+		options: opts.Options,
+		ttl:     opts.CacheTTL,
+		cache:   map[string]cachedProvider{},
+	}
+}
+
+func tenantProviderKey(tenant, name string) string {
+	return "goth_fiber:tenant_provider:" + tenant + ":" + name
+}
+
+func tenantIndexKey(tenant string) string {
+	return "goth_fiber:tenant_providers:" + tenant
+}
+
+// Put stores the configuration of a provider of a tenant.
+func (r *StorageResolver) Put(config TenantProviderConfig) error {
+	b, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+
+	if err := r.storage.Set(tenantProviderKey(config.Tenant, config.Name), b, 0); err != nil {
+		return err
+	}
+
+	if err := r.updateIndex(config.Tenant, config.Name, true); err != nil {
+		return err
+	}
+
+	r.invalidate(config.Tenant, config.Name)
+	return nil
+}
+
+// Delete removes the configuration of a provider of a tenant.
+func (r *StorageResolver) Delete(tenant, name string) error {
+	if err := r.storage.Delete(tenantProviderKey(tenant, name)); err != nil {
+		return err
+	}
+
+	if err := r.updateIndex(tenant, name, false); err != nil {
+		return err
+	}
+
+	r.invalidate(tenant, name)
+	return nil
+}
+
+// GetProvider implements ProviderResolver.
+func (r *StorageResolver) GetProvider(ctx fiber.Ctx, name string) (goth.Provider, error) {
+	cached, err := r.provider(r.tenant(ctx), name)
+	if err != nil {
+		return nil, err
+	}
+	return cached.provider, nil
+}
+
+// GetProviderOptions implements ProviderResolver.
+func (r *StorageResolver) GetProviderOptions(ctx fiber.Ctx, name string) (ProviderOptions, bool) {
+	cached, err := r.provider(r.tenant(ctx), name)
+	if err != nil || cached.options == nil {
+		return ProviderOptions{}, false
+	}
+	return *cached.options, true
+}
+
+// Tenant implements ProviderResolver.
+func (r *StorageResolver) Tenant(ctx fiber.Ctx) string {
+	return r.tenant(ctx)
+}
+
+// GetProviders implements ProviderResolver.
+func (r *StorageResolver) GetProviders(ctx fiber.Ctx) (goth.Providers, error) {
+	tenant := r.tenant(ctx)
+
+	names, err := r.index(tenant)
+	if err != nil {
+		return nil, err
+	}
+
+	providers := goth.Providers{}
+	for _, name := range names {
+		cached, err := r.provider(tenant, name)
+		if err != nil {
+			return nil, err
+		}
+		providers[name] = cached.provider
+	}
+
+	return providers, nil
+}
+
+// provider returns the provider of a tenant along with its options, built
+// from the storage when they are not cached.
+func (r *StorageResolver) provider(tenant, name string) (cachedProvider, error) {
+	key := tenantProviderKey(tenant, name)
+
+	r.mu.Lock()
+	cached, ok := r.cache[key]
+	r.mu.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached, nil
+	}
+
+	b, err := r.storage.Get(key)
+	if err != nil {
+		return cachedProvider{}, err
+	}
+	if b == nil {
+		return cachedProvider{}, fmt.Errorf("%w: %s", ErrUnknownProvider, name)
+	}
+
+	var config TenantProviderConfig
+	if err := json.Unmarshal(b, &config); err != nil {
+		return cachedProvider{}, err
+	}
+
+	provider, err := r.build(config)
+	if err != nil {
+		return cachedProvider{}, err
+	}
+	provider.SetName(name)
+
+	cached = cachedProvider{provider: provider, expires: time.Now().Add(r.ttl)}
+	if r.options != nil {
+		opts := r.options(config)
+		cached.options = &opts
+	}
+
+	r.mu.Lock()
+	r.cache[key] = cached
+	r.mu.Unlock()
+
+	return cached, nil
+}
+
+func (r *StorageResolver) invalidate(tenant, name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.cache, tenantProviderKey(tenant, name))
+}
+
+func (r *StorageResolver) index(tenant string) ([]string, error) {
+	b, err := r.storage.Get(tenantIndexKey(tenant))
+	if err != nil || b == nil {
+		return nil, err
+	}
+
+	var names []string
+	err = json.Unmarshal(b, &names)
+	return names, err
+}
+
+func (r *StorageResolver) updateIndex(tenant, name string, add bool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	names, err := r.index(tenant)
+	if err != nil {
+		return err
+	}
+
+	updated := names[:0]
+	for _, n := range names {
+		if n != name {
+			updated = append(updated, n)
+		}
+	}
+	if add {
+		updated = append(updated, name)
+	}
+
+	b, err := json.Marshal(updated)
+	if err != nil {
+		return err
+	}
+
+	return r.storage.Set(tenantIndexKey(tenant), b, 0)
+}
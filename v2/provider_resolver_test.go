@@ -0,0 +1,191 @@
+package goth_fiber
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/markbates/goth"
+)
+
+func Test_MemoryResolver(t *testing.T) {
+	acme := newFakeAuthServer(t)
+	globex := newFakeAuthServer(t)
+
+	resolver := NewMemoryResolver(TenantFromHeader("X-Tenant"))
+	resolver.Use("acme", newTestOAuth2Provider(acme, "sso", "http://acme.localhost/callback/sso", nil))
+	resolver.Use("globex", newTestOAuth2Provider(globex, "sso", "http://globex.localhost/callback/sso", nil))
+
+	Resolver = resolver
+	defer func() { Resolver = nil }()
+
+	app := fiber.New()
+	app.Get("/auth/:provider", func(c fiber.Ctx) error {
+		url, err := GetAuthURL(c)
+		if err != nil {
+			return c.Status(ErrorStatus(err)).SendString(ErrorCode(err))
+		}
+		return c.SendString(url)
+	})
+
+	for tenant, server := range map[string]*fakeAuthServer{"acme": acme, "globex": globex} {
+		req := httptest.NewRequest("GET", "/auth/sso", nil)
+		req.Header.Set("X-Tenant", tenant)
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		body, _ := io.ReadAll(resp.Body)
+		if !strings.HasPrefix(string(body), server.URL+"/authorize?") {
+			t.Errorf("expected the provider of %s, got %s", tenant, body)
+		}
+	}
+
+	req := httptest.NewRequest("GET", "/auth/sso", nil)
+	req.Header.Set("X-Tenant", "initech")
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != fiber.StatusBadRequest {
+		t.Errorf("expected unknown tenants to get no provider, got %d", resp.StatusCode)
+	}
+}
+
+func Test_StorageResolver(t *testing.T) {
+	servers := map[string]*fakeAuthServer{"acme": newFakeAuthServer(t)}
+	builds := 0
+
+	resolver := NewStorageResolver(newMemoryStorage(), TenantFromSubdomain, func(config TenantProviderConfig) (goth.Provider, error) {
+		builds++
+		return newTestOAuth2Provider(servers[config.Tenant], config.Name, config.CallbackURL, nil), nil
+	}, StorageResolverOptions{
+		Options: func(config TenantProviderConfig) ProviderOptions {
+			return ProviderOptions{ClientID: config.ClientID}
+		},
+	})
+
+	err := resolver.Put(TenantProviderConfig{
+		Tenant:      "acme",
+		Name:        "sso",
+		ClientID:    "client",
+		CallbackURL: "https://acme.example.com/callback/sso",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := fiber.New()
+	app.Get("/", func(c fiber.Ctx) error {
+		provider, err := resolver.GetProvider(c, "sso")
+		if err != nil {
+			return err
+		}
+		if _, err := resolver.GetProvider(c, "sso"); err != nil {
+			return err
+		}
+
+		providers, err := resolver.GetProviders(c)
+		if err != nil {
+			return err
+		}
+		if len(providers) != 1 || providers["sso"] != provider {
+			t.Errorf("unexpected providers %v", providers)
+		}
+
+		if opts, ok := resolver.GetProviderOptions(c, "sso"); !ok || opts.ClientID != "client" {
+			t.Errorf("expected the options built from the configuration, got %+v", opts)
+		}
+		if resolver.Tenant(c) != "acme" {
+			t.Errorf("unexpected tenant %q", resolver.Tenant(c))
+		}
+
+		if err := resolver.Delete("acme", "sso"); err != nil {
+			return err
+		}
+		if _, err := resolver.GetProvider(c, "sso"); !errors.Is(err, ErrUnknownProvider) {
+			t.Errorf("expected ErrUnknownProvider once deleted, got %v", err)
+		}
+		return nil
+	})
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Host = "acme.example.com"
+	if _, err := app.Test(req); err != nil {
+		t.Fatal(err)
+	}
+
+	if builds != 1 {
+		t.Errorf("expected the provider to be built once and cached, built %d times", builds)
+	}
+}
+
+func Test_MemoryResolver_TenantIsolation(t *testing.T) {
+	acme := newFakeAuthServer(t)
+	globex := newFakeAuthServer(t)
+
+	resolver := NewMemoryResolver(TenantFromHeader("X-Tenant"))
+	resolver.Use("acme", newTestOAuth2Provider(acme, "sso", "http://acme.localhost/callback/sso", nil))
+	resolver.Use("globex", newTestOAuth2Provider(globex, "sso", "http://globex.localhost/callback/sso", nil))
+	resolver.SetOptions("acme", "sso", ProviderOptions{PKCE: true})
+
+	Resolver = resolver
+	defer func() { Resolver = nil }()
+
+	app := fiber.New()
+	app.Get("/auth/:provider", func(c fiber.Ctx) error {
+		url, err := GetAuthURL(c)
+		if err != nil {
+			return err
+		}
+		return c.SendString(url)
+	})
+	app.Get("/login", func(c fiber.Ctx) error {
+		return StoreUserInSession(goth.User{Provider: "sso", UserID: "1"}, c)
+	})
+	app.Get("/me", func(c fiber.Ctx) error {
+		user, err := CurrentUser(c)
+		if err != nil {
+			return c.Status(ErrorStatus(err)).SendString(ErrorCode(err))
+		}
+		return c.SendString(user.UserID)
+	})
+
+	do := func(tenant, path string, cookies []*http.Cookie) *http.Response {
+		t.Helper()
+
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("X-Tenant", tenant)
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	for tenant, pkce := range map[string]bool{"acme": true, "globex": false} {
+		body, _ := io.ReadAll(do(tenant, "/auth/sso", nil).Body)
+		if strings.Contains(string(body), "code_challenge=") != pkce {
+			t.Errorf("expected the options of %s to apply, got %s", tenant, body)
+		}
+	}
+
+	cookies := do("acme", "/login", nil).Cookies()
+	if body, _ := io.ReadAll(do("acme", "/me", cookies).Body); string(body) != "1" {
+		t.Errorf("expected the user on its tenant, got %s", body)
+	}
+
+	resp := do("globex", "/me", cookies)
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != fiber.StatusUnauthorized || string(body) != "tenant_mismatch" {
+		t.Errorf("expected the session to be rejected on another tenant, got %d %s", resp.StatusCode, body)
+	}
+}
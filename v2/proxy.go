@@ -0,0 +1,126 @@
+package goth_fiber
+
+import (
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/gofiber/fiber/v3/middleware/proxy"
+	"github.com/valyala/fasthttp"
+)
+
+// Options that affect how ProxyWithToken works.
+type ProxyOptions struct {
+	// Client used to forward the request.
+	//
+	// Defaults to the client of fiber's proxy package.
+	Client *fasthttp.Client
+
+	// Timeout of the upstream request. Zero means no timeout.
+	Timeout time.Duration
+
+	// StripPrefix is removed from the request path before it is appended to the target.
+	StripPrefix string
+
+	// Unauthorized is called when the session has no user or its token cannot be obtained.
+	//
+	// Defaults to responding with 401 Unauthorized.
+	Unauthorized func(ctx fiber.Ctx, err error) error
+}
+
+/*
+ProxyWithToken returns a handler that forwards the request to target with the
+access token of the user stored in the session, as a bearer token or, when it
+is DPoP-bound, with a DPoP proof for the upstream request. The request path and
+query are appended to target.
+
+The token is refreshed through TokenSource when needed, and the session cookie
+is removed from the forwarded request. Please note that any options provided in
+addition to the first will be ignored.
+*/
+func ProxyWithToken(target string, options ...ProxyOptions) fiber.Handler {
+	var opts ProxyOptions
+	if len(options) > 0 {
+		opts = options[0]
+	}
+
+	if opts.Unauthorized == nil {
+		opts.Unauthorized = func(ctx fiber.Ctx, err error) error {
+			return ctx.Status(fiber.StatusUnauthorized).SendString(err.Error())
+		}
+	}
+
+	target = strings.TrimSuffix(target, "/")
+
+	return func(ctx fiber.Ctx) error {
+		ts, err := TokenSource(ctx)
+		if err != nil {
+			return opts.Unauthorized(ctx, err)
+		}
+
+		token, err := ts.Token()
+		if err != nil {
+			return opts.Unauthorized(ctx, err)
+		}
+
+		addr := target + strings.TrimPrefix(ctx.OriginalURL(), opts.StripPrefix)
+
+		req := ctx.Request()
+		req.Header.Set(fiber.HeaderAuthorization, token.Type()+" "+token.AccessToken)
+		req.Header.Del("DPoP")
+
+		// DPoP-bound tokens need a proof for the upstream request
+		origin := ""
+		if strings.EqualFold(token.Type(), "DPoP") {
+			user, err := GetUserFromSession(ctx)
+			if err != nil {
+				return opts.Unauthorized(ctx, err)
+			}
+
+			key := sessionDPoPKey(ctx, user.Provider)
+			if key == nil {
+				return opts.Unauthorized(ctx, ErrInvalidToken)
+			}
+
+			u, err := url.Parse(addr)
+			if err != nil {
+				return err
+			}
+			origin = u.Scheme + "://" + u.Host
+
+			nonce := ""
+			if n, ok := dpopNonces.Load(origin); ok {
+				nonce = n.(string)
+			}
+
+			proof, err := dpopProof(key, ctx.Method(), addr, token.AccessToken, nonce)
+			if err != nil {
+				return err
+			}
+			req.Header.Set("DPoP", proof)
+		}
+
+		if name := SessionManager.cookieName(); name != "" {
+			req.Header.DelCookie(name)
+		}
+
+		var clients []*fasthttp.Client
+		if opts.Client != nil {
+			clients = append(clients, opts.Client)
+		}
+
+		if opts.Timeout > 0 {
+			err = proxy.DoTimeout(ctx, addr, opts.Timeout, clients...)
+		} else {
+			err = proxy.Do(ctx, addr, clients...)
+		}
+
+		// the next proofs for the upstream carry its latest nonce
+		if nonce := ctx.Response().Header.Peek("DPoP-Nonce"); origin != "" && len(nonce) > 0 {
+			dpopNonces.Store(origin, string(nonce))
+		}
+
+		return err
+	}
+}
@@ -0,0 +1,141 @@
+package goth_fiber
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/markbates/goth"
+	"github.com/markbates/goth/gothic"
+	"github.com/markbates/goth/providers/faux"
+)
+
+func Test_ProxyWithToken(t *testing.T) {
+	goth.ClearProviders()
+	goth.UseProviders(&faux.Provider{})
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := r.Cookie(gothic.SessionName); err == nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		_, _ = io.WriteString(w, r.URL.Path+" "+r.Header.Get("Authorization"))
+	}))
+	defer upstream.Close()
+
+	app := fiber.New()
+	app.Get("/login", func(c fiber.Ctx) error {
+		return StoreUserInSession(goth.User{
+			Provider:    "faux",
+			AccessToken: "access",
+			ExpiresAt:   time.Now().Add(time.Hour),
+		}, c)
+	})
+	app.Get("/api/*", ProxyWithToken(upstream.URL, ProxyOptions{StripPrefix: "/api"}))
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/login", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookies := resp.Cookies()
+
+	req := httptest.NewRequest("GET", "/api/users/me", nil)
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+	resp, err = app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != 200 || string(body) != "/users/me Bearer access" {
+		t.Errorf("unexpected upstream response %d: %s", resp.StatusCode, string(body))
+	}
+}
+
+func Test_ProxyWithToken_DPoP(t *testing.T) {
+	server := newFakeAuthServer(t)
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scheme, accessToken, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+		key, _, err := checkDPoPProof(r.Header.Get("DPoP"), r.Method, "http://"+r.Host+r.URL.Path, accessToken)
+
+		server.mu.Lock()
+		boundKey := server.dpopTokens[accessToken]
+		server.mu.Unlock()
+
+		if scheme != "DPoP" || err != nil || key != boundKey {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = io.WriteString(w, r.Method+" "+r.URL.Path+" "+accessToken)
+	}))
+	defer upstream.Close()
+
+	goth.ClearProviders()
+	goth.UseProviders(newTestOAuth2Provider(server, "dpop", "http://localhost/callback/dpop", ExchangeClient(nil)))
+	SetProviderOptions("dpop", ProviderOptions{DPoP: true})
+	defer SetProviderOptions("dpop", ProviderOptions{})
+
+	app := fiber.New()
+	app.Get("/auth/:provider", BeginAuthHandler)
+	app.Get("/callback/:provider", func(c fiber.Ctx) error {
+		_, err := CompleteUserAuth(c, CompleteUserAuthOptions{ShouldLogout: false})
+		return err
+	})
+	app.All("/api/*", ProxyWithToken(upstream.URL, ProxyOptions{StripPrefix: "/api"}))
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/auth/dpop", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookies := resp.Cookies()
+
+	authURL := resp.Header.Get("Location")
+	location, _ := url.Parse(authURL)
+	callback := "/callback/dpop?" + url.Values{
+		"code":  {server.issueCode(authURL)},
+		"state": {location.Query().Get("state")},
+	}.Encode()
+
+	for _, r := range []struct{ method, path, body string }{
+		{"GET", callback, ""},
+		{"GET", "/api/users/me?x=1", "GET /users/me at-code-" + location.Query().Get("state")},
+		{"POST", "/api/users", "POST /users at-code-" + location.Query().Get("state")},
+	} {
+		req := httptest.NewRequest(r.method, r.path, nil)
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		if resp, err = app.Test(req); err != nil {
+			t.Fatal(err)
+		}
+
+		body, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != fiber.StatusOK || string(body) != r.body {
+			t.Fatalf("%s %s: unexpected response %d: %s", r.method, r.path, resp.StatusCode, body)
+		}
+	}
+}
+
+func Test_ProxyWithToken_Unauthorized(t *testing.T) {
+	t.Parallel()
+
+	app := fiber.New()
+	app.Get("/api/*", ProxyWithToken("http://127.0.0.1:1"))
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/api/users/me", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.StatusCode != fiber.StatusUnauthorized {
+		t.Errorf("expected status %d, got %d", fiber.StatusUnauthorized, resp.StatusCode)
+	}
+}
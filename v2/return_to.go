@@ -0,0 +1,82 @@
+package goth_fiber
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/gofiber/fiber/v3"
+)
+
+// ReturnToParam is the query parameter GetAuthURL reads the URL to send the
+// user back to after authentication from.
+const ReturnToParam = "rd"
+
+const returnToSessionKey = "_goth_return_to"
+
+// AllowedReturnToHosts lists the hosts, besides the one serving the callback,
+// that users may be sent back to after authentication. Entries starting with
+// a dot match any subdomain, e.g. ".example.com".
+var AllowedReturnToHosts []string
+
+// GetReturnTo returns the URL passed as ReturnToParam when the authentication
+// was started, or an empty string if there is none or it is not allowed.
+// It is meant to be used in the callback, after CompleteUserAuth, or in the
+// page of an onboarding gate, after CompleteGate.
+func GetReturnTo(ctx fiber.Ctx) string {
+	returnTo, ok := ctx.Locals(returnToKey).(string)
+	if !ok {
+		var err error
+		if returnTo, err = GetFromSession(returnToSessionKey, ctx); err != nil {
+			return ""
+		}
+	}
+
+	if !isAllowedReturnTo(returnTo, ctx.Hostname()) {
+		return ""
+	}
+
+	return returnTo
+}
+
+// isAllowedReturnTo reports whether returnTo is a local path or an absolute
+// http(s) URL pointing at host or one of AllowedReturnToHosts.
+func isAllowedReturnTo(returnTo, host string) bool {
+	u, err := url.Parse(returnTo)
+	if err != nil {
+		return false
+	}
+
+	if u.Scheme == "" && u.Host == "" {
+		// reject protocol-relative and backslash tricks like "//evil" or "/\evil"
+		return strings.HasPrefix(returnTo, "/") && !strings.HasPrefix(returnTo, "//") && !strings.HasPrefix(returnTo, "/\\")
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return false
+	}
+
+	target := u.Hostname()
+	if target == host {
+		return true
+	}
+
+	for _, allowed := range AllowedReturnToHosts {
+		if target == allowed || (strings.HasPrefix(allowed, ".") && strings.HasSuffix(target, allowed)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// takeReturnTo moves the return-to URL from the session to Locals, so that it
+// is used once.
+func takeReturnTo(ctx fiber.Ctx) error {
+	returnTo, err := GetFromSession(returnToSessionKey, ctx)
+	if err != nil || returnTo == "" {
+		return nil
+	}
+
+	ctx.Locals(returnToKey, returnTo)
+	return StoreInSession(returnToSessionKey, "", ctx)
+}
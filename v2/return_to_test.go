@@ -0,0 +1,125 @@
+package goth_fiber
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/markbates/goth"
+	"github.com/markbates/goth/providers/faux"
+)
+
+func Test_isAllowedReturnTo(t *testing.T) {
+	original := AllowedReturnToHosts
+	defer func() {
+		AllowedReturnToHosts = original
+	}()
+	AllowedReturnToHosts = []string{"app.example.com", ".internal.example.com"}
+
+	tests := []struct {
+		returnTo string
+		allowed  bool
+	}{
+		{"/dashboard?tab=1", true},
+		{"//evil.com/path", false},
+		{"/\\evil.com", false},
+		{"dashboard", false},
+		{"https://auth.example.com/x", true},
+		{"https://app.example.com/x", true},
+		{"https://grafana.internal.example.com/", true},
+		{"https://evil.com/", false},
+		{"javascript:alert(1)", false},
+	}
+
+	for _, tt := range tests {
+		if got := isAllowedReturnTo(tt.returnTo, "auth.example.com"); got != tt.allowed {
+			t.Errorf("isAllowedReturnTo(%q) = %v, want %v", tt.returnTo, got, tt.allowed)
+		}
+	}
+}
+
+func Test_GetReturnTo_AfterCompleteUserAuth(t *testing.T) {
+	goth.ClearProviders()
+	goth.UseProviders(&faux.Provider{})
+
+	app := fiber.New()
+	app.Get("/auth/:provider", BeginAuthHandler)
+	app.Get("/callback/:provider", func(c fiber.Ctx) error {
+		if _, err := CompleteUserAuth(c); err != nil {
+			return c.Status(400).SendString(err.Error())
+		}
+		return c.SendString(GetReturnTo(c))
+	})
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/auth/faux?state=test-state&rd=%2Fsettings", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookies := resp.Cookies()
+
+	req := httptest.NewRequest("GET", "/callback/faux?code=test-code&state=test-state", nil)
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+	resp, err = app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != "/settings" {
+		t.Errorf("expected return-to '/settings', got %d: %s", resp.StatusCode, string(body))
+	}
+}
+
+func Test_GetReturnTo_NotReused(t *testing.T) {
+	goth.ClearProviders()
+	goth.UseProviders(&faux.Provider{})
+
+	app := fiber.New()
+	app.Get("/auth/:provider", BeginAuthHandler)
+	app.Get("/callback/:provider", func(c fiber.Ctx) error {
+		if _, err := CompleteUserAuth(c, CompleteUserAuthOptions{ShouldLogout: false}); err != nil {
+			return c.Status(400).SendString(err.Error())
+		}
+		return c.SendString(GetReturnTo(c))
+	})
+	app.Get("/return-to", func(c fiber.Ctx) error {
+		return c.SendString(GetReturnTo(c))
+	})
+
+	var cookies []*http.Cookie
+	get := func(path string) string {
+		t.Helper()
+
+		req := httptest.NewRequest("GET", path, nil)
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c := resp.Cookies(); len(c) > 0 {
+			cookies = c
+		}
+		body, _ := io.ReadAll(resp.Body)
+		return string(body)
+	}
+
+	get("/auth/faux?state=test-state&rd=%2Fsettings")
+	get("/auth/faux?state=test-state")
+	if got := get("/callback/faux?code=test-code&state=test-state"); got != "" {
+		t.Errorf("expected the return-to of the abandoned flow to be cleared, got %q", got)
+	}
+
+	get("/auth/faux?state=test-state&rd=%2Fsettings")
+	if got := get("/callback/faux?code=test-code&state=test-state"); got != "/settings" {
+		t.Errorf("unexpected return-to %q", got)
+	}
+	if got := get("/return-to"); got != "" {
+		t.Errorf("expected the return-to to be cleared once consumed, got %q", got)
+	}
+}
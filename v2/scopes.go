@@ -0,0 +1,200 @@
+package goth_fiber
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/markbates/goth"
+)
+
+// ScopeParam is the query parameter of the begin request holding the extra
+// scopes to request, space separated. It is ignored for providers without
+// ProviderOptions.AllowedScopes.
+const ScopeParam = "scope"
+
+const (
+	// requestedScopesSessionKey prefixes the session key holding the scopes requested from a provider.
+	requestedScopesSessionKey = "_goth_requested_scopes_"
+
+	// grantedScopesSessionKey prefixes the session key holding the scopes granted by a provider.
+	grantedScopesSessionKey = "_goth_scopes_"
+)
+
+var (
+	// ErrScopeNotAllowed is returned by GetAuthURL when the begin request
+	// asks for a scope outside of ProviderOptions.AllowedScopes.
+	ErrScopeNotAllowed = errors.New("goth_fiber: scope not allowed")
+
+	// ErrInsufficientScope is returned by RequireScopes when the scopes
+	// granted to the user do not include the required ones.
+	ErrInsufficientScope = errors.New("goth_fiber: insufficient scope")
+)
+
+// GetContextWithScopes adds scopes to the ones requested by GetAuthURL for
+// the request. Unlike the ScopeParam query parameter, they are not checked
+// against ProviderOptions.AllowedScopes.
+func GetContextWithScopes(ctx fiber.Ctx, scopes ...string) fiber.Ctx {
+	existing, _ := ctx.Locals(scopesKey).([]string)
+	ctx.Locals(scopesKey, append(existing, scopes...))
+	return ctx
+}
+
+/*
+applyScopes adds the extra scopes of the request to the scope of authURL,
+asks for incremental authorization when configured, and records the scopes
+requested in the session.
+*/
+func applyScopes(ctx fiber.Ctx, providerName, authURL string, opts ProviderOptions) (string, error) {
+	extra, _ := ctx.Locals(scopesKey).([]string)
+	if len(opts.AllowedScopes) > 0 {
+		for _, scope := range strings.Fields(ctx.Query(ScopeParam)) {
+			if !containsString(opts.AllowedScopes, scope) {
+				return "", fmt.Errorf("%w: %s", ErrScopeNotAllowed, scope)
+			}
+			extra = append(extra, scope)
+		}
+	}
+
+	u, err := url.Parse(authURL)
+	if err != nil {
+		return "", err
+	}
+
+	query := u.Query()
+	scopes := strings.Fields(query.Get("scope"))
+	for _, scope := range extra {
+		if !containsString(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+
+	if len(extra) > 0 || opts.IncrementalAuthorization {
+		if len(extra) > 0 {
+			query.Set("scope", strings.Join(scopes, " "))
+		}
+		if opts.IncrementalAuthorization {
+			query.Set("include_granted_scopes", "true")
+		}
+		u.RawQuery = query.Encode()
+		authURL = u.String()
+	}
+
+	// also without scopes, so that those of an earlier flow are not taken
+	// for this one's
+	if err := StoreInSession(requestedScopesSessionKey+providerName, strings.Join(scopes, " "), ctx); err != nil {
+		return "", err
+	}
+
+	return authURL, nil
+}
+
+/*
+recordGrantedScopes stores the scopes granted by the code exchange in the
+session. The scope of the token response is used when the provider uses
+ExchangeClient and sends one, otherwise the scopes requested are assumed to
+be granted, as RFC 6749 allows providers to omit them.
+
+With IncrementalAuthorization, the scopes granted before are kept when the
+session user is the same identity as user, which is not stored yet.
+*/
+func recordGrantedScopes(ctx fiber.Ctx, providerName string, user goth.User, capture *tokenCapture) error {
+	capture.mu.Lock()
+	scope := capture.scope
+	capture.mu.Unlock()
+
+	if scope == "" {
+		requested, err := GetFromSession(requestedScopesSessionKey+providerName, ctx)
+		if err != nil {
+			return nil
+		}
+		scope = requested
+	}
+
+	granted := strings.Fields(scope)
+	if requestProviderOptions(ctx, providerName).IncrementalAuthorization && sameSessionUser(ctx, user) {
+		if previous, err := GetFromSession(grantedScopesSessionKey+providerName, ctx); err == nil {
+			for _, s := range strings.Fields(previous) {
+				if !containsString(granted, s) {
+					granted = append(granted, s)
+				}
+			}
+		}
+	}
+
+	return StoreInSession(grantedScopesSessionKey+providerName, strings.Join(granted, " "), ctx)
+}
+
+// sameSessionUser reports whether the user stored in the session is the same
+// identity as user.
+func sameSessionUser(ctx fiber.Ctx, user goth.User) bool {
+	previous, err := GetUserFromSession(ctx)
+	return err == nil && IdentityOf(previous) == IdentityOf(user)
+}
+
+/*
+GrantedScopes returns the scopes granted to the current user. For users
+logged in through CompleteUserAuth, they are the scopes recorded in the
+session. For users authenticated by BearerAuth, they come from the "scope" or
+"scp" claim of the token.
+*/
+func GrantedScopes(ctx fiber.Ctx) ([]string, error) {
+	user, err := CurrentUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if SessionManager != nil {
+		if granted, err := GetFromSession(grantedScopesSessionKey+user.Provider, ctx); err == nil {
+			return strings.Fields(granted), nil
+		}
+	}
+
+	if scope := stringClaim(user.RawData, "scope"); scope != "" {
+		return strings.Fields(scope), nil
+	}
+
+	var scopes []string
+	if scp, ok := user.RawData["scp"].([]interface{}); ok {
+		for _, s := range scp {
+			if s, ok := s.(string); ok {
+				scopes = append(scopes, s)
+			}
+		}
+	}
+	return scopes, nil
+}
+
+/*
+RequireScopes returns a middleware letting requests through only when the
+scopes granted to the current user, see GrantedScopes, include all of scopes.
+Other requests are handed to AuthorizationErrorHandler with
+ErrInsufficientScope, or ErrNotAuthenticated without a user.
+*/
+func RequireScopes(scopes ...string) fiber.Handler {
+	return func(ctx fiber.Ctx) error {
+		granted, err := GrantedScopes(ctx)
+		if err != nil {
+			return AuthorizationErrorHandler(ctx, fmt.Errorf("%w: %w", ErrNotAuthenticated, err))
+		}
+
+		for _, scope := range scopes {
+			if !containsString(granted, scope) {
+				return AuthorizationErrorHandler(ctx, fmt.Errorf("%w: %s", ErrInsufficientScope, scope))
+			}
+		}
+
+		return ctx.Next()
+	}
+}
+
+func containsString(list []string, value string) bool {
+	for _, s := range list {
+		if s == value {
+			return true
+		}
+	}
+	return false
+}
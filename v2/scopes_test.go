@@ -0,0 +1,114 @@
+package goth_fiber
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/markbates/goth"
+)
+
+func Test_IncrementalScopes(t *testing.T) {
+	server := newFakeAuthServer(t)
+
+	goth.ClearProviders()
+	goth.UseProviders(newTestOAuth2Provider(server, "scoped", "http://localhost/callback/scoped", ExchangeClient(nil)))
+	SetProviderOptions("scoped", ProviderOptions{
+		AllowedScopes:            []string{"drive"},
+		IncrementalAuthorization: true,
+	})
+	defer SetProviderOptions("scoped", ProviderOptions{})
+
+	app := fiber.New()
+	app.Get("/auth/:provider", BeginAuthHandler)
+	app.Get("/callback/:provider", func(c fiber.Ctx) error {
+		_, err := CompleteUserAuth(c, CompleteUserAuthOptions{ShouldLogout: false})
+		return err
+	})
+	app.Get("/drive", RequireScopes("drive"), func(c fiber.Ctx) error {
+		return c.SendString("ok")
+	})
+
+	var cookies []*http.Cookie
+	do := func(path string) *http.Response {
+		t.Helper()
+
+		req := httptest.NewRequest("GET", path, nil)
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(resp.Cookies()) > 0 {
+			cookies = resp.Cookies()
+		}
+		return resp
+	}
+
+	login := func(begin string) {
+		t.Helper()
+
+		resp := do(begin)
+		authURL := resp.Header.Get("Location")
+		location, _ := url.Parse(authURL)
+		if location.Query().Get("include_granted_scopes") != "true" {
+			t.Errorf("expected incremental authorization, got %s", authURL)
+		}
+
+		resp = do("/callback/scoped?" + url.Values{
+			"code":  {server.issueCode(authURL)},
+			"state": {location.Query().Get("state")},
+		}.Encode())
+		if resp.StatusCode != fiber.StatusOK {
+			t.Fatalf("login failed with %d", resp.StatusCode)
+		}
+	}
+
+	login("/auth/scoped")
+	if resp := do("/drive"); resp.StatusCode != fiber.StatusForbidden {
+		t.Errorf("expected the drive scope to be missing, got %d", resp.StatusCode)
+	}
+
+	if resp := do("/auth/scoped?scope=admin"); resp.StatusCode != fiber.StatusBadRequest {
+		t.Errorf("expected scopes outside of the allowlist to be rejected, got %d", resp.StatusCode)
+	}
+
+	login("/auth/scoped?scope=drive")
+	if resp := do("/drive"); resp.StatusCode != fiber.StatusOK {
+		t.Errorf("expected the drive scope to be granted, got %d", resp.StatusCode)
+	}
+
+	// the scopes granted to another user of the session are not kept
+	server.mu.Lock()
+	server.subject = "43"
+	server.mu.Unlock()
+
+	login("/auth/scoped")
+	if resp := do("/drive"); resp.StatusCode != fiber.StatusForbidden {
+		t.Errorf("expected the drive scope of the previous user not to be kept, got %d", resp.StatusCode)
+	}
+}
+
+func Test_GrantedScopes_BearerClaims(t *testing.T) {
+	t.Parallel()
+
+	app := fiber.New()
+	app.Get("/", func(c fiber.Ctx) error {
+		c.Locals(userKey, goth.User{RawData: map[string]interface{}{"scp": []interface{}{"read", "write"}}})
+		return c.Next()
+	}, RequireScopes("read", "write"), func(c fiber.Ctx) error {
+		return c.SendString("ok")
+	})
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != fiber.StatusOK {
+		t.Errorf("expected the scopes of the token claims to be granted, got %d", resp.StatusCode)
+	}
+}
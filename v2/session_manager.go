@@ -1,10 +1,10 @@
 package goth_fiber
 
 import (
-	"errors"
-
 	"github.com/gofiber/fiber/v3"
+	"github.com/gofiber/fiber/v3/extractors"
 	"github.com/gofiber/fiber/v3/middleware/session"
+	"github.com/markbates/goth/gothic"
 )
 
 type sessionManager struct {
@@ -47,7 +47,7 @@ func (m *sessionManager) getValue(c fiber.Ctx, key string) (string, error) {
 		return value, nil
 	}
 
-	return "", errors.New("could not find a matching session for this request")
+	return "", ErrSessionNotFound
 }
 
 // set value in session
@@ -100,3 +100,18 @@ func (m *sessionManager) delSession(c fiber.Ctx) error {
 
 	return nil
 }
+
+// cookieName returns the name of the session cookie, if the session id is read from one
+func (m *sessionManager) cookieName() string {
+	if m.session == nil {
+		return gothic.SessionName
+	}
+
+	for _, e := range append([]extractors.Extractor{m.session.Extractor}, m.session.Extractor.Chain...) {
+		if e.Source == extractors.SourceCookie && e.Key != "" {
+			return e.Key
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,125 @@
+package goth_fiber
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+)
+
+// memoryStorage is a minimal in-memory fiber.Storage used when no storage is
+// configured. Expired entries are dropped lazily.
+type memoryStorage struct {
+	mu      sync.RWMutex
+	data    map[string]memoryEntry
+	evicted time.Time
+}
+
+// memoryEvictInterval is how often Set scans memoryStorage for expired entries.
+const memoryEvictInterval = time.Minute
+
+type memoryEntry struct {
+	value  []byte
+	expiry time.Time
+}
+
+var _ fiber.Storage = (*memoryStorage)(nil)
+
+func newMemoryStorage() *memoryStorage {
+	return &memoryStorage{data: map[string]memoryEntry{}}
+}
+
+func (s *memoryStorage) Get(key string) ([]byte, error) {
+	s.mu.RLock()
+	e, ok := s.data[key]
+	s.mu.RUnlock()
+
+	if !ok || (!e.expiry.IsZero() && time.Now().After(e.expiry)) {
+		return nil, nil
+	}
+
+	return append([]byte(nil), e.value...), nil
+}
+
+func (s *memoryStorage) Set(key string, val []byte, exp time.Duration) error {
+	if key == "" || len(val) == 0 {
+		return nil
+	}
+
+	e := memoryEntry{value: append([]byte(nil), val...)}
+	if exp > 0 {
+		e.expiry = time.Now().Add(exp)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.evictExpired()
+	s.data[key] = e
+	return nil
+}
+
+// evictExpired drops expired entries at most every memoryEvictInterval, so
+// that the storage is not scanned on every write. The caller must hold s.mu.
+func (s *memoryStorage) evictExpired() {
+	now := time.Now()
+	if now.Sub(s.evicted) < memoryEvictInterval {
+		return
+	}
+	s.evicted = now
+
+	for k, v := range s.data {
+		if !v.expiry.IsZero() && now.After(v.expiry) {
+			delete(s.data, k)
+		}
+	}
+}
+
+func (s *memoryStorage) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.data, key)
+	return nil
+}
+
+func (s *memoryStorage) Reset() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.data = map[string]memoryEntry{}
+	return nil
+}
+
+func (s *memoryStorage) Close() error {
+	return nil
+}
+
+func (s *memoryStorage) GetWithContext(ctx context.Context, key string) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return s.Get(key)
+}
+
+func (s *memoryStorage) SetWithContext(ctx context.Context, key string, val []byte, exp time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.Set(key, val, exp)
+}
+
+func (s *memoryStorage) DeleteWithContext(ctx context.Context, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.Delete(key)
+}
+
+func (s *memoryStorage) ResetWithContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.Reset()
+}
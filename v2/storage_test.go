@@ -0,0 +1,38 @@
+package goth_fiber
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_MemoryStorage_EvictsExpired(t *testing.T) {
+	t.Parallel()
+
+	s := newMemoryStorage()
+	if err := s.Set("old", []byte("v"), time.Nanosecond); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond)
+
+	// writes within the interval do not scan the storage
+	if err := s.Set("new", []byte("v"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.data["old"]; !ok {
+		t.Error("expected the expired entry to be kept until the next sweep")
+	}
+	if v, _ := s.Get("old"); v != nil {
+		t.Errorf("expected no value for an expired entry, got %q", v)
+	}
+
+	s.evicted = time.Now().Add(-memoryEvictInterval)
+	if err := s.Set("newer", []byte("v"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.data["old"]; ok {
+		t.Error("expected the expired entry to be dropped by the sweep")
+	}
+	if len(s.data) != 2 {
+		t.Errorf("expected the live entries to be kept, got %d", len(s.data))
+	}
+}
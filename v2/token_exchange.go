@@ -0,0 +1,105 @@
+package goth_fiber
+
+import (
+	"encoding/json"
+	"errors"
+	"net/url"
+	"strings"
+
+	"github.com/gofiber/fiber/v3"
+	"golang.org/x/oauth2"
+)
+
+const (
+	tokenExchangeGrantType = "urn:ietf:params:oauth:grant-type:token-exchange"
+	accessTokenType        = "urn:ietf:params:oauth:token-type:access_token"
+
+	// tokenExchangeSessionKey prefixes the session keys caching exchanged tokens.
+	tokenExchangeSessionKey = "_goth_exchange_"
+)
+
+// ErrTokenExchangeNotConfigured is returned by ExchangeToken when the
+// ProviderOptions of the user's provider have no TokenURL or ClientID.
+var ErrTokenExchangeNotConfigured = errors.New("goth_fiber: token exchange requires ProviderOptions.TokenURL and ClientID")
+
+/*
+ExchangeToken exchanges the access token of the user stored in the session
+for a token scoped to another audience, following OAuth 2.0 Token Exchange
+(RFC 8693). The request goes to the TokenURL of the ProviderOptions of the
+user's provider, with the client authentication configured there.
+
+The session access token is refreshed first if needed, see TokenSource.
+Exchanged tokens are cached in the session per user, audience and scopes until
+they expire.
+*/
+func ExchangeToken(ctx fiber.Ctx, audience string, scopes []string) (*oauth2.Token, error) {
+	user, err := GetUserFromSession(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := requestProviderOptions(ctx, user.Provider)
+	if opts.TokenURL == "" || opts.ClientID == "" {
+		return nil, ErrTokenExchangeNotConfigured
+	}
+
+	// the user is part of the key, so tokens exchanged for a previous user
+	// of the session are never handed out to the next one
+	cacheKey := tokenExchangeSessionKey + user.Provider + ":" + user.UserID + ":" + audience + ":" + strings.Join(scopes, " ")
+	if value, err := GetFromSession(cacheKey, ctx); err == nil {
+		var token oauth2.Token
+		if json.Unmarshal([]byte(value), &token) == nil && token.Valid() {
+			return &token, nil
+		}
+	}
+
+	ts, err := TokenSource(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	subject, err := ts.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"grant_type":           {tokenExchangeGrantType},
+		"subject_token":        {subject.AccessToken},
+		"subject_token_type":   {accessTokenType},
+		"requested_token_type": {accessTokenType},
+		"audience":             {audience},
+		"client_id":            {opts.ClientID},
+	}
+	if len(scopes) > 0 {
+		form.Set("scope", strings.Join(scopes, " "))
+	}
+
+	clientSecret, err := authenticateClient(form, opts, opts.ClientID, opts.TokenURL)
+	if err != nil {
+		return nil, err
+	}
+
+	var res tokenResponse
+	if err := postForm(ctx.Context(), nil, opts.TokenURL, form, opts.ClientID, clientSecret, &res); err != nil {
+		return nil, err
+	}
+
+	token := res.token()
+	if token.AccessToken == "" {
+		return nil, errors.New("goth_fiber: token exchange response without access_token")
+	}
+
+	// tokens without expiry are not cached, they could be revoked at any time
+	if !token.Expiry.IsZero() {
+		b, err := json.Marshal(token)
+		if err != nil {
+			return nil, err
+		}
+		if err := StoreInSession(cacheKey, string(b), ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	return token, nil
+}
@@ -0,0 +1,117 @@
+package goth_fiber
+
+import (
+	"errors"
+	"io"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/markbates/goth"
+)
+
+func Test_ExchangeToken(t *testing.T) {
+	server := newFakeAuthServer(t)
+
+	goth.ClearProviders()
+	goth.UseProviders(newTestOAuth2Provider(server, "exchange", "http://localhost/callback/exchange", nil))
+	SetProviderOptions("exchange", ProviderOptions{
+		TokenURL:     server.URL + "/token",
+		ClientID:     "client",
+		ClientSecret: "secret",
+	})
+	defer SetProviderOptions("exchange", ProviderOptions{})
+
+	app := fiber.New()
+	app.Get("/login", func(c fiber.Ctx) error {
+		return StoreUserInSession(goth.User{
+			Provider:    "exchange",
+			UserID:      c.Query("user", "1"),
+			AccessToken: c.Query("token", "user-token"),
+			ExpiresAt:   time.Now().Add(time.Hour),
+		}, c)
+	})
+	app.Get("/exchange/:audience", func(c fiber.Ctx) error {
+		token, err := ExchangeToken(c, c.Params("audience"), []string{"read", "write"})
+		if err != nil {
+			return err
+		}
+		return c.SendString(token.AccessToken)
+	})
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/login", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookies := resp.Cookies()
+
+	exchange := func(audience string) string {
+		t.Helper()
+
+		req := httptest.NewRequest("GET", "/exchange/"+audience, nil)
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		return string(body)
+	}
+
+	if token := exchange("billing"); token != "billing:user-token" {
+		t.Fatalf("unexpected exchanged token %q", token)
+	}
+
+	form := server.lastTokenForm()
+	if form.Get("subject_token_type") != accessTokenType || form.Get("scope") != "read write" {
+		t.Errorf("unexpected token exchange request %v", form)
+	}
+
+	if token := exchange("billing"); token != "billing:user-token" {
+		t.Fatalf("unexpected cached token %q", token)
+	}
+	if token := exchange("reports"); token != "reports:user-token" {
+		t.Fatalf("unexpected exchanged token %q", token)
+	}
+
+	if len(server.tokenForms) != 2 {
+		t.Errorf("expected the billing token to be cached, got %d exchanges", len(server.tokenForms))
+	}
+
+	req := httptest.NewRequest("GET", "/login?user=2&token=other-token", nil)
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+	if _, err := app.Test(req); err != nil {
+		t.Fatal(err)
+	}
+
+	if token := exchange("billing"); token != "billing:other-token" {
+		t.Errorf("expected a token exchanged for the new user of the session, got %q", token)
+	}
+}
+
+func Test_ExchangeToken_NotConfigured(t *testing.T) {
+	goth.ClearProviders()
+	goth.UseProviders(newTestOAuth2Provider(newFakeAuthServer(t), "exchange", "http://localhost/callback/exchange", nil))
+
+	app := fiber.New()
+	app.Get("/", func(c fiber.Ctx) error {
+		if err := StoreUserInSession(goth.User{Provider: "exchange", AccessToken: "user-token"}, c); err != nil {
+			return err
+		}
+
+		_, err := ExchangeToken(c, "billing", nil)
+		if !errors.Is(err, ErrTokenExchangeNotConfigured) {
+			t.Errorf("expected ErrTokenExchangeNotConfigured, got %v", err)
+		}
+		return nil
+	})
+
+	if _, err := app.Test(httptest.NewRequest("GET", "/", nil)); err != nil {
+		t.Fatal(err)
+	}
+}
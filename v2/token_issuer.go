@@ -0,0 +1,452 @@
+package goth_fiber
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/markbates/goth"
+)
+
+// ErrInvalidRefreshToken is returned when a refresh token is unknown, expired,
+// revoked or has already been used.
+var ErrInvalidRefreshToken = errors.New("goth_fiber: invalid refresh token")
+
+// Options that affect how a TokenIssuer works.
+type TokenIssuerOptions struct {
+	// Algorithm used to sign access tokens: HS256, RS256, ES256 or EdDSA
+	// (and their 384/512 variants).
+	//
+	// Defaults to HS256.
+	Algorithm string
+
+	// SigningKey is a []byte secret for the HS algorithms, at least as long
+	// as their hash (32 bytes for HS256), or an *rsa.PrivateKey,
+	// *ecdsa.PrivateKey or ed25519.PrivateKey.
+	SigningKey interface{}
+
+	// KeyID is set as the "kid" header of issued tokens.
+	KeyID string
+
+	// Issuer and Audience are set as the "iss" and "aud" claims and checked by Middleware.
+	Issuer   string
+	Audience string
+
+	// AccessTokenTTL is the lifetime of access tokens.
+	//
+	// Defaults to 15 minutes.
+	AccessTokenTTL time.Duration
+
+	// RefreshTokenTTL is the lifetime of refresh tokens.
+	//
+	// Defaults to 30 days.
+	RefreshTokenTTL time.Duration
+
+	// Claims returns additional claims for the user, added to the default
+	// sub, idp, email, name, preferred_username and picture claims. They are
+	// computed at login and carried over when tokens are refreshed.
+	Claims func(user goth.User) map[string]interface{}
+
+	// Storage keeps the refresh tokens. Refresh serialises the rotations of
+	// a token within the process only: fiber.Storage has no atomic
+	// compare-and-set, so instances sharing a storage can rotate a token
+	// presented to each of them at once twice. Share one only through a
+	// backend whose Set of a used token is an atomic compare-and-set.
+	//
+	// Defaults to an in-memory storage.
+	Storage fiber.Storage
+}
+
+// IssuedTokens is the token response returned by a TokenIssuer.
+type IssuedTokens struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// TokenIssuer mints its own JWT access tokens and rotating refresh tokens
+// for users authenticated through goth, for clients that cannot rely on the
+// session cookie.
+type TokenIssuer struct {
+	opts      TokenIssuerOptions
+	verifyKey interface{}
+
+	// refreshLocks serialises the rotations of each refresh token
+	refreshLocks keyedMutex
+}
+
+// refreshRecord is what is stored for a refresh token.
+type refreshRecord struct {
+	Claims    map[string]interface{} `json:"claims"`
+	Family    string                 `json:"family"`
+	ExpiresAt time.Time              `json:"expires_at"`
+	Used      bool                   `json:"used,omitempty"`
+}
+
+// NewTokenIssuer creates a TokenIssuer, checking that the signing key matches the algorithm.
+func NewTokenIssuer(opts TokenIssuerOptions) (*TokenIssuer, error) {
+	if opts.Algorithm == "" {
+		opts.Algorithm = "HS256"
+	}
+
+	if opts.AccessTokenTTL == 0 {
+		opts.AccessTokenTTL = 15 * time.Minute
+	}
+
+	if opts.RefreshTokenTTL == 0 {
+		opts.RefreshTokenTTL = 30 * 24 * time.Hour
+	}
+
+	if opts.Storage == nil {
+		opts.Storage = newMemoryStorage()
+	}
+
+	var verifyKey interface{}
+	switch k := opts.SigningKey.(type) {
+	case []byte:
+		// RFC 7518 requires HMAC keys of at least the size of the hash
+		if hash, err := jwtHash(opts.Algorithm); err == nil && strings.HasPrefix(opts.Algorithm, "HS") && len(k) < hash.Size() {
+			return nil, fmt.Errorf("goth_fiber: %s secret must be at least %d bytes", opts.Algorithm, hash.Size())
+		}
+		verifyKey = k
+	case *rsa.PrivateKey, *ecdsa.PrivateKey, ed25519.PrivateKey:
+		verifyKey = k.(crypto.Signer).Public()
+	default:
+		return nil, fmt.Errorf("goth_fiber: unsupported signing key type %T", opts.SigningKey)
+	}
+
+	// fail early on a key that does not fit the algorithm
+	if _, err := jwtSign(opts.Algorithm, opts.SigningKey, nil); err != nil {
+		return nil, err
+	}
+
+	return &TokenIssuer{opts: opts, verifyKey: verifyKey}, nil
+}
+
+// Issue mints an access token and a new refresh token family for the user.
+func (i *TokenIssuer) Issue(user goth.User) (*IssuedTokens, error) {
+	claims := map[string]interface{}{
+		"sub": user.UserID,
+		"idp": user.Provider,
+	}
+	setClaim(claims, "email", user.Email)
+	setClaim(claims, "name", user.Name)
+	setClaim(claims, "preferred_username", user.NickName)
+	setClaim(claims, "picture", user.AvatarURL)
+
+	if i.opts.Claims != nil {
+		for k, v := range i.opts.Claims(user) {
+			claims[k] = v
+		}
+	}
+
+	family, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+
+	return i.issue(claims, family)
+}
+
+func (i *TokenIssuer) issue(claims map[string]interface{}, family string) (*IssuedTokens, error) {
+	now := time.Now()
+
+	access := make(map[string]interface{}, len(claims)+5)
+	for k, v := range claims {
+		access[k] = v
+	}
+	access["iat"] = now.Unix()
+	access["exp"] = now.Add(i.opts.AccessTokenTTL).Unix()
+	setClaim(access, "iss", i.opts.Issuer)
+	setClaim(access, "aud", i.opts.Audience)
+
+	jti, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+	access["jti"] = jti
+
+	accessToken, err := signJWT(jwtHeader{Alg: i.opts.Algorithm, Kid: i.opts.KeyID}, access, i.opts.SigningKey)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+
+	err = i.saveRefresh(refreshToken, refreshRecord{
+		Claims:    claims,
+		Family:    family,
+		ExpiresAt: now.Add(i.opts.RefreshTokenTTL),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// the family points at its current refresh token, so that it can be revoked as a whole
+	err = i.opts.Storage.Set(familyStorageKey(family), []byte(refreshStorageKey(refreshToken)), i.opts.RefreshTokenTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	return &IssuedTokens{
+		AccessToken:  accessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(i.opts.AccessTokenTTL / time.Second),
+		RefreshToken: refreshToken,
+	}, nil
+}
+
+// Refresh rotates a refresh token: it is consumed and a new token pair is
+// returned. Presenting an already used refresh token revokes its whole family.
+func (i *TokenIssuer) Refresh(refreshToken string) (*IssuedTokens, error) {
+	// concurrent refreshes of the same token must not both see it unused
+	unlock := i.refreshLocks.lock(refreshStorageKey(refreshToken))
+	defer unlock()
+
+	rec, err := i.loadRefresh(refreshToken)
+	if err != nil {
+		return nil, err
+	}
+
+	if rec.Used {
+		// replay of a rotated token, assume it leaked and cut off the family
+		if err := i.revokeFamily(rec.Family); err != nil {
+			return nil, err
+		}
+		return nil, ErrInvalidRefreshToken
+	}
+
+	rec.Used = true
+	if err := i.saveRefresh(refreshToken, rec); err != nil {
+		return nil, err
+	}
+
+	return i.issue(rec.Claims, rec.Family)
+}
+
+// Revoke revokes the refresh token family the given refresh token belongs to.
+func (i *TokenIssuer) Revoke(refreshToken string) error {
+	rec, err := i.loadRefresh(refreshToken)
+	if err != nil {
+		return err
+	}
+
+	return i.revokeFamily(rec.Family)
+}
+
+/*
+CallbackHandler completes the authentication with CompleteUserAuth and
+responds with freshly issued tokens as JSON.
+*/
+func (i *TokenIssuer) CallbackHandler(ctx fiber.Ctx) error {
+	user, err := CompleteUserAuth(ctx)
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).SendString(err.Error())
+	}
+
+	tokens, err := i.Issue(user)
+	if err != nil {
+		return err
+	}
+
+	return ctx.JSON(tokens)
+}
+
+// refreshRequest is the body accepted by RefreshHandler and RevokeHandler,
+// either as JSON or as a form.
+type refreshRequest struct {
+	RefreshToken string `json:"refresh_token" form:"refresh_token"`
+}
+
+// RefreshHandler exchanges the "refresh_token" of the request body for a new token pair.
+func (i *TokenIssuer) RefreshHandler(ctx fiber.Ctx) error {
+	var req refreshRequest
+	if err := ctx.Bind().Body(&req); err != nil || req.RefreshToken == "" {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid_request"})
+	}
+
+	tokens, err := i.Refresh(req.RefreshToken)
+	if errors.Is(err, ErrInvalidRefreshToken) {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid_grant"})
+	}
+	if err != nil {
+		return err
+	}
+
+	return ctx.JSON(tokens)
+}
+
+// RevokeHandler revokes the "refresh_token" of the request body. Like RFC 7009,
+// it responds with 200 OK even when the token was not valid.
+func (i *TokenIssuer) RevokeHandler(ctx fiber.Ctx) error {
+	var req refreshRequest
+	if err := ctx.Bind().Body(&req); err != nil || req.RefreshToken == "" {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid_request"})
+	}
+
+	if err := i.Revoke(req.RefreshToken); err != nil && !errors.Is(err, ErrInvalidRefreshToken) {
+		return err
+	}
+
+	return ctx.SendStatus(fiber.StatusOK)
+}
+
+// Middleware verifies access tokens issued by this TokenIssuer and makes the
+// user available through CurrentUser.
+func (i *TokenIssuer) Middleware(options ...BearerAuthOptions) fiber.Handler {
+	var opts BearerAuthOptions
+	if len(options) > 0 {
+		opts = options[0]
+	}
+
+	opts.Keys = map[string]interface{}{i.opts.KeyID: i.verifyKey}
+	opts.JWKSURL = ""
+	opts.IntrospectionURL = ""
+	opts.Issuer = i.opts.Issuer
+	opts.Audience = i.opts.Audience
+	opts.UserFromClaims = func(token string, claims map[string]interface{}) goth.User {
+		user := goth.User{
+			RawData:     claims,
+			Provider:    stringClaim(claims, "idp"),
+			UserID:      stringClaim(claims, "sub"),
+			Email:       stringClaim(claims, "email"),
+			Name:        stringClaim(claims, "name"),
+			NickName:    stringClaim(claims, "preferred_username"),
+			AvatarURL:   stringClaim(claims, "picture"),
+			AccessToken: token,
+		}
+		if exp, ok := numericClaim(claims, "exp"); ok {
+			user.ExpiresAt = exp
+		}
+		return user
+	}
+
+	return BearerAuth(opts)
+}
+
+func (i *TokenIssuer) saveRefresh(refreshToken string, rec refreshRecord) error {
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	return i.opts.Storage.Set(refreshStorageKey(refreshToken), b, time.Until(rec.ExpiresAt))
+}
+
+func (i *TokenIssuer) loadRefresh(refreshToken string) (refreshRecord, error) {
+	var rec refreshRecord
+
+	b, err := i.opts.Storage.Get(refreshStorageKey(refreshToken))
+	if err != nil {
+		return rec, err
+	}
+
+	if b == nil {
+		return rec, ErrInvalidRefreshToken
+	}
+
+	if err := json.Unmarshal(b, &rec); err != nil {
+		return rec, err
+	}
+
+	if time.Now().After(rec.ExpiresAt) {
+		return rec, ErrInvalidRefreshToken
+	}
+
+	return rec, nil
+}
+
+func (i *TokenIssuer) revokeFamily(family string) error {
+	current, err := i.opts.Storage.Get(familyStorageKey(family))
+	if err != nil {
+		return err
+	}
+
+	if current != nil {
+		if err := i.opts.Storage.Delete(string(current)); err != nil {
+			return err
+		}
+	}
+
+	return i.opts.Storage.Delete(familyStorageKey(family))
+}
+
+// keyedMutex is a mutex per key, dropped once nobody holds or waits for it.
+type keyedMutex struct {
+	mu    sync.Mutex
+	locks map[string]*keyedLock
+}
+
+type keyedLock struct {
+	sync.Mutex
+	refs int
+}
+
+// lock locks key and returns the function unlocking it.
+func (m *keyedMutex) lock(key string) func() {
+	m.mu.Lock()
+	if m.locks == nil {
+		m.locks = map[string]*keyedLock{}
+	}
+	l, ok := m.locks[key]
+	if !ok {
+		l = &keyedLock{}
+		m.locks[key] = l
+	}
+	l.refs++
+	m.mu.Unlock()
+
+	l.Lock()
+
+	return func() {
+		l.Unlock()
+
+		m.mu.Lock()
+		if l.refs--; l.refs == 0 {
+			delete(m.locks, key)
+		}
+		m.mu.Unlock()
+	}
+}
+
+// refreshStorageKey only stores a hash, a leaked storage does not leak usable tokens.
+func refreshStorageKey(refreshToken string) string {
+	sum := sha256.Sum256([]byte(refreshToken))
+	return "goth_fiber:refresh:" + hex.EncodeToString(sum[:])
+}
+
+func familyStorageKey(family string) string {
+	return "goth_fiber:refresh_family:" + family
+}
+
+func setClaim(claims map[string]interface{}, name, value string) {
+	if value != "" {
+		claims[name] = value
+	}
+}
+
+// randomToken returns 32 random bytes, base64url encoded.
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
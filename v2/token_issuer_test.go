@@ -0,0 +1,202 @@
+package goth_fiber
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/markbates/goth"
+)
+
+func Test_TokenIssuer_IssueAndVerify(t *testing.T) {
+	t.Parallel()
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	issuer, err := NewTokenIssuer(TokenIssuerOptions{
+		Algorithm:  "EdDSA",
+		SigningKey: key,
+		KeyID:      "k1",
+		Issuer:     "https://app.example.com",
+		Claims: func(user goth.User) map[string]interface{} {
+			return map[string]interface{}{"tenant": "acme"}
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tokens, err := issuer.Issue(goth.User{Provider: "github", UserID: "42", Email: "jane@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := fiber.New()
+	app.Get("/me", issuer.Middleware(), func(c fiber.Ctx) error {
+		user, err := CurrentUser(c)
+		if err != nil {
+			return err
+		}
+		return c.SendString(user.Provider + ":" + user.UserID + ":" + user.RawData["tenant"].(string))
+	})
+
+	req := httptest.NewRequest("GET", "/me", nil)
+	req.Header.Set("Authorization", "Bearer "+tokens.AccessToken)
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != "github:42:acme" {
+		t.Errorf("expected user from issued token, got %d: %s", resp.StatusCode, string(body))
+	}
+}
+
+func Test_TokenIssuer_RejectsMismatchedKey(t *testing.T) {
+	t.Parallel()
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewTokenIssuer(TokenIssuerOptions{Algorithm: "RS256", SigningKey: key}); err == nil {
+		t.Error("expected an error for an Ed25519 key with RS256")
+	}
+}
+
+// testSecret is long enough for HS256.
+var testSecret = []byte("0123456789abcdef0123456789abcdef")
+
+func Test_TokenIssuer_RejectsShortSecret(t *testing.T) {
+	t.Parallel()
+
+	if _, err := NewTokenIssuer(TokenIssuerOptions{SigningKey: []byte("secret")}); err == nil {
+		t.Error("expected an error for a 6 bytes HS256 secret")
+	}
+	if _, err := NewTokenIssuer(TokenIssuerOptions{Algorithm: "HS512", SigningKey: testSecret}); err == nil {
+		t.Error("expected an error for a 32 bytes HS512 secret")
+	}
+}
+
+func Test_TokenIssuer_ConcurrentRefresh(t *testing.T) {
+	t.Parallel()
+
+	issuer, err := NewTokenIssuer(TokenIssuerOptions{SigningKey: testSecret})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tokens, err := issuer.Issue(goth.User{Provider: "github", UserID: "42"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	rotated := 0
+	for n := 0; n < 10; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := issuer.Refresh(tokens.RefreshToken); err == nil {
+				mu.Lock()
+				rotated++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if rotated != 1 {
+		t.Errorf("expected the refresh token to be rotated once, got %d", rotated)
+	}
+}
+
+func Test_TokenIssuer_RefreshRotationAndReuse(t *testing.T) {
+	t.Parallel()
+
+	issuer, err := NewTokenIssuer(TokenIssuerOptions{SigningKey: testSecret})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := issuer.Issue(goth.User{Provider: "github", UserID: "42"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := issuer.Refresh(first.RefreshToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.RefreshToken == first.RefreshToken {
+		t.Fatal("expected the refresh token to rotate")
+	}
+
+	// replaying the first token revokes the family, including the second token
+	if _, err := issuer.Refresh(first.RefreshToken); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Fatalf("expected ErrInvalidRefreshToken on reuse, got %v", err)
+	}
+	if _, err := issuer.Refresh(second.RefreshToken); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Fatalf("expected the family to be revoked, got %v", err)
+	}
+}
+
+func Test_TokenIssuer_Handlers(t *testing.T) {
+	t.Parallel()
+
+	issuer, err := NewTokenIssuer(TokenIssuerOptions{SigningKey: testSecret})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tokens, err := issuer.Issue(goth.User{Provider: "github", UserID: "42"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := fiber.New()
+	app.Post("/token/refresh", issuer.RefreshHandler)
+	app.Post("/token/revoke", issuer.RevokeHandler)
+
+	post := func(path, body string) (int, []byte) {
+		req := httptest.NewRequest("POST", path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, b
+	}
+
+	status, body := post("/token/refresh", `{"refresh_token":"`+tokens.RefreshToken+`"}`)
+	if status != 200 {
+		t.Fatalf("expected status 200 on refresh, got %d: %s", status, string(body))
+	}
+
+	var refreshed IssuedTokens
+	if err := json.Unmarshal(body, &refreshed); err != nil {
+		t.Fatal(err)
+	}
+
+	if status, body = post("/token/revoke", `{"refresh_token":"`+refreshed.RefreshToken+`"}`); status != 200 {
+		t.Fatalf("expected status 200 on revoke, got %d: %s", status, string(body))
+	}
+
+	if status, _ = post("/token/refresh", `{"refresh_token":"`+refreshed.RefreshToken+`"}`); status != fiber.StatusBadRequest {
+		t.Errorf("expected status %d for revoked token, got %d", fiber.StatusBadRequest, status)
+	}
+}
@@ -0,0 +1,216 @@
+package goth_fiber
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/markbates/goth"
+	"golang.org/x/oauth2"
+)
+
+// tokenTypeSessionKey prefixes the session key holding the type of the
+// access token issued by a provider.
+const tokenTypeSessionKey = "_goth_token_type_"
+
+// ErrTokenNotRefreshable is returned by a TokenSource when the stored access
+// token has expired and the provider or session offers no way to refresh it.
+var ErrTokenNotRefreshable = errors.New("goth_fiber: access token expired and cannot be refreshed")
+
+/*
+TokenSource returns an oauth2.TokenSource built from the tokens of the user
+stored in the session by CompleteUserAuth (called with ShouldLogout set to false).
+
+Expired tokens are refreshed through the user's goth provider and the refreshed
+tokens are written back to the session. Because of that, the returned source
+must only be used while the request that ctx belongs to is being handled.
+*/
+func TokenSource(ctx fiber.Ctx) (oauth2.TokenSource, error) {
+	if SessionManager == nil {
+		return nil, ErrSessionNil
+	}
+
+	user, err := GetUserFromSession(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	provider, err := getProvider(ctx, user.Provider)
+	if err != nil {
+		return nil, err
+	}
+
+	return oauth2.ReuseTokenSource(tokenFromUser(user, sessionTokenType(ctx, user.Provider)), &sessionTokenSource{
+		ctx:      ctx,
+		provider: provider,
+	}), nil
+}
+
+// HTTPClient returns an *http.Client that authenticates its requests as the
+// user stored in the session. See TokenSource for the refresh behaviour. When
+// the tokens are DPoP-bound, the requests carry DPoP proofs.
+func HTTPClient(ctx fiber.Ctx) (*http.Client, error) {
+	ts, err := TokenSource(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := GetUserFromSession(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if key := sessionDPoPKey(ctx, user.Provider); key != nil && strings.EqualFold(sessionTokenType(ctx, user.Provider), "DPoP") {
+		return &http.Client{Transport: &dpopTransport{source: ts, key: key}}, nil
+	}
+
+	return oauth2.NewClient(ctx.Context(), ts), nil
+}
+
+// sessionTokenSource refreshes the session user's token with its provider.
+type sessionTokenSource struct {
+	ctx      fiber.Ctx
+	provider goth.Provider
+}
+
+func (s *sessionTokenSource) Token() (*oauth2.Token, error) {
+	// reload the user, another source may have already rotated the refresh token
+	user, err := GetUserFromSession(s.ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if !s.provider.RefreshTokenAvailable() || user.RefreshToken == "" {
+		return nil, ErrTokenNotRefreshable
+	}
+
+	tokenRequestOptions.Store(user.RefreshToken, requestProviderOptions(s.ctx, s.provider.Name()))
+	defer tokenRequestOptions.Delete(user.RefreshToken)
+
+	if key := sessionDPoPKey(s.ctx, s.provider.Name()); key != nil {
+		dpopKeys.Store(user.RefreshToken, key)
+		defer dpopKeys.Delete(user.RefreshToken)
+	}
+
+	token, err := s.provider.RefreshToken(user.RefreshToken)
+	if err != nil {
+		return nil, err
+	}
+
+	if token == nil {
+		return nil, ErrTokenNotRefreshable
+	}
+
+	user.AccessToken = token.AccessToken
+	user.ExpiresAt = token.Expiry
+	if token.RefreshToken != "" {
+		user.RefreshToken = token.RefreshToken
+	}
+
+	if idToken, ok := token.Extra("id_token").(string); ok && idToken != "" {
+		user.IDToken = idToken
+	}
+
+	if err := StoreUserInSession(user, s.ctx); err != nil {
+		return nil, err
+	}
+
+	if token.TokenType != "" {
+		if err := StoreInSession(tokenTypeSessionKey+s.provider.Name(), token.TokenType, s.ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	return tokenFromUser(user, sessionTokenType(s.ctx, s.provider.Name())), nil
+}
+
+// sessionTokenType returns the type of the access token the session holds
+// for a provider, as sent by its token endpoint. When it is unknown, tokens
+// are assumed to be DPoP-bound if the session has a DPoP key.
+func sessionTokenType(ctx fiber.Ctx, providerName string) string {
+	if tokenType, err := GetFromSession(tokenTypeSessionKey+providerName, ctx); err == nil && tokenType != "" {
+		return tokenType
+	}
+
+	if sessionDPoPKey(ctx, providerName) != nil {
+		return "DPoP"
+	}
+	return "Bearer"
+}
+
+// tokenFromUser converts the tokens held by a goth.User into an oauth2.Token.
+// An empty tokenType means a bearer token.
+func tokenFromUser(user goth.User, tokenType string) *oauth2.Token {
+	return &oauth2.Token{
+		AccessToken:  user.AccessToken,
+		TokenType:    tokenType,
+		RefreshToken: user.RefreshToken,
+		Expiry:       user.ExpiresAt,
+	}
+}
+
+// tokenResponses maps the authorization codes being exchanged to the
+// tokenCapture exchangeTransport records the token response in.
+var tokenResponses sync.Map
+
+// tokenCapture holds what CompleteUserAuth needs of a token response that
+// goth providers do not keep.
+type tokenCapture struct {
+	mu        sync.Mutex
+	scope     string
+	tokenType string
+}
+
+// captureTokenResponse records the scope and token type of a code exchange
+// response for CompleteUserAuth.
+func captureTokenResponse(form url.Values, resp *http.Response) error {
+	if form.Get("grant_type") != "authorization_code" || resp.StatusCode != http.StatusOK {
+		return nil
+	}
+
+	value, ok := tokenResponses.Load(form.Get("code"))
+	if !ok {
+		return nil
+	}
+
+	b, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+
+	var res struct {
+		Scope     string `json:"scope"`
+		TokenType string `json:"token_type"`
+	}
+	if json.Unmarshal(b, &res) != nil {
+		return nil
+	}
+
+	capture := value.(*tokenCapture)
+	capture.mu.Lock()
+	capture.scope, capture.tokenType = res.Scope, res.TokenType
+	capture.mu.Unlock()
+	return nil
+}
+
+// recordTokenResponse stores the granted scopes and the token type of a code
+// exchange of user in the session.
+func recordTokenResponse(ctx fiber.Ctx, providerName string, user goth.User, capture *tokenCapture) error {
+	if err := recordGrantedScopes(ctx, providerName, user, capture); err != nil {
+		return err
+	}
+
+	capture.mu.Lock()
+	tokenType := capture.tokenType
+	capture.mu.Unlock()
+
+	return StoreInSession(tokenTypeSessionKey+providerName, tokenType, ctx)
+}
@@ -0,0 +1,145 @@
+package goth_fiber
+
+import (
+	"errors"
+	"io"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/markbates/goth"
+	"github.com/markbates/goth/providers/faux"
+	"golang.org/x/oauth2"
+)
+
+// refreshingProvider is a faux provider that can refresh tokens.
+type refreshingProvider struct {
+	faux.Provider
+}
+
+func (p *refreshingProvider) Name() string {
+	return "refreshing"
+}
+
+func (p *refreshingProvider) RefreshTokenAvailable() bool {
+	return true
+}
+
+func (p *refreshingProvider) RefreshToken(refreshToken string) (*oauth2.Token, error) {
+	return &oauth2.Token{
+		AccessToken:  "refreshed-access",
+		RefreshToken: "rotated-" + refreshToken,
+		Expiry:       time.Now().Add(time.Hour),
+	}, nil
+}
+
+func Test_TokenSource_RefreshesAndPersists(t *testing.T) {
+	goth.ClearProviders()
+	goth.UseProviders(&refreshingProvider{})
+
+	app := fiber.New()
+	app.Get("/login", func(c fiber.Ctx) error {
+		return StoreUserInSession(goth.User{
+			Provider:     "refreshing",
+			AccessToken:  "stale-access",
+			RefreshToken: "refresh",
+			ExpiresAt:    time.Now().Add(-time.Minute),
+		}, c)
+	})
+	app.Get("/token", func(c fiber.Ctx) error {
+		ts, err := TokenSource(c)
+		if err != nil {
+			return c.Status(500).SendString(err.Error())
+		}
+		token, err := ts.Token()
+		if err != nil {
+			return c.Status(500).SendString(err.Error())
+		}
+		return c.SendString(token.AccessToken)
+	})
+	app.Get("/me", func(c fiber.Ctx) error {
+		user, err := GetUserFromSession(c)
+		if err != nil {
+			return c.Status(401).SendString(err.Error())
+		}
+		return c.SendString(user.AccessToken + "," + user.RefreshToken)
+	})
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/login", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookies := resp.Cookies()
+
+	req := httptest.NewRequest("GET", "/token", nil)
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+	resp, err = app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != "refreshed-access" {
+		t.Fatalf("expected refreshed access token, got %d: %s", resp.StatusCode, string(body))
+	}
+
+	req = httptest.NewRequest("GET", "/me", nil)
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+	resp, err = app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, _ = io.ReadAll(resp.Body)
+	if string(body) != "refreshed-access,rotated-refresh" {
+		t.Errorf("expected refreshed tokens to be persisted, got '%s'", string(body))
+	}
+}
+
+func Test_TokenSource_NotRefreshable(t *testing.T) {
+	goth.ClearProviders()
+	goth.UseProviders(&faux.Provider{})
+
+	app := fiber.New()
+	app.Get("/", func(c fiber.Ctx) error {
+		err := StoreUserInSession(goth.User{
+			Provider:    "faux",
+			AccessToken: "stale-access",
+			ExpiresAt:   time.Now().Add(-time.Minute),
+		}, c)
+		if err != nil {
+			return err
+		}
+
+		ts, err := TokenSource(c)
+		if err != nil {
+			return err
+		}
+		if _, err := ts.Token(); !errors.Is(err, ErrTokenNotRefreshable) {
+			return c.Status(500).SendString("unexpected error: " + errString(err))
+		}
+		return c.SendString("ok")
+	})
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.StatusCode != 200 {
+		body, _ := io.ReadAll(resp.Body)
+		t.Errorf("expected status 200, got %d: %s", resp.StatusCode, string(body))
+	}
+}
+
+func errString(err error) string {
+	if err == nil {
+		return "<nil>"
+	}
+	return err.Error()
+}
@@ -0,0 +1,74 @@
+package goth_fiber
+
+import (
+	"encoding/json"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/markbates/goth"
+)
+
+// UserSessionKey is the session key under which CompleteUserAuth keeps the
+// authenticated user when it is told not to end the session.
+const UserSessionKey = "_goth_user"
+
+// tenantSessionKey holds the tenant the session user logged in through, see ProviderResolver.
+const tenantSessionKey = "_goth_tenant"
+
+// StoreUserInSession stores the authenticated user, including its tokens, in
+// the session, bound to the tenant of the request.
+func StoreUserInSession(user goth.User, ctx fiber.Ctx) error {
+	b, err := json.Marshal(user)
+	if err != nil {
+		return err
+	}
+
+	if err := StoreInSession(tenantSessionKey, requestTenant(ctx), ctx); err != nil {
+		return err
+	}
+
+	if err := StoreInSession(UserSessionKey, string(b), ctx); err != nil {
+		return err
+	}
+
+	ctx.Locals(userKey, user)
+	return nil
+}
+
+// GetUserFromSession retrieves the user previously stored by CompleteUserAuth.
+// If no user has been stored in the session, it will return an error, and
+// ErrTenantMismatch if it was stored for another tenant.
+func GetUserFromSession(ctx fiber.Ctx) (goth.User, error) {
+	value, err := GetFromSession(UserSessionKey, ctx)
+	if err != nil {
+		return goth.User{}, err
+	}
+
+	tenant, _ := GetFromSession(tenantSessionKey, ctx)
+	if tenant != requestTenant(ctx) {
+		return goth.User{}, ErrTenantMismatch
+	}
+
+	var user goth.User
+	if err := json.Unmarshal([]byte(value), &user); err != nil {
+		return goth.User{}, err
+	}
+
+	return user, nil
+}
+
+// CurrentUser returns the user authenticated for the current request. That is
+// the user set by a middleware such as BearerAuth or, failing that, the user
+// stored in the session by CompleteUserAuth.
+func CurrentUser(ctx fiber.Ctx) (goth.User, error) {
+	if user, ok := ctx.Locals(userKey).(goth.User); ok {
+		return user, nil
+	}
+
+	user, err := GetUserFromSession(ctx)
+	if err != nil {
+		return goth.User{}, err
+	}
+
+	ctx.Locals(userKey, user)
+	return user, nil
+}
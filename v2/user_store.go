@@ -0,0 +1,246 @@
+package goth_fiber
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/markbates/goth"
+)
+
+var (
+	// ErrAccountNotFound is returned by UserStore lookups finding no account.
+	ErrAccountNotFound = errors.New("goth_fiber: account not found")
+
+	// ErrIdentityInUse is returned when linking an identity that belongs to
+	// another account.
+	ErrIdentityInUse = errors.New("goth_fiber: identity is linked to another account")
+
+	// ErrLastIdentity is returned when unlinking the only identity of an
+	// account, which could not be logged in to anymore.
+	ErrLastIdentity = errors.New("goth_fiber: cannot unlink the last identity of an account")
+)
+
+// Identity is an account at a provider, as identified by goth.User.Provider
+// and goth.User.UserID.
+type Identity struct {
+	Provider string `json:"provider"`
+	UserID   string `json:"user_id"`
+}
+
+// IdentityOf returns the identity of user.
+func IdentityOf(user goth.User) Identity {
+	return Identity{Provider: user.Provider, UserID: user.UserID}
+}
+
+// Account is an application account, which users log in to with any of its
+// identities. EmailVerified is set when the provider of the identity the
+// account was created with reported its email as verified.
+type Account struct {
+	ID            string            `json:"id"`
+	Email         string            `json:"email"`
+	EmailVerified bool              `json:"email_verified"`
+	Name          string            `json:"name"`
+	Identities    []Identity        `json:"identities"`
+	Attributes    map[string]string `json:"attributes,omitempty"`
+	CreatedAt     time.Time         `json:"created_at"`
+}
+
+/*
+UserStore keeps the accounts of an application and the identities linked to
+them, for AccountCallbackHandler. Lookups return ErrAccountNotFound when there
+is no matching account.
+*/
+type UserStore interface {
+	// FindByIdentity returns the account an identity is linked to.
+	FindByIdentity(ctx context.Context, identity Identity) (*Account, error)
+
+	// FindByEmail returns the account with the given email, compared case
+	// insensitively, preferring accounts whose email is verified.
+	FindByEmail(ctx context.Context, email string) (*Account, error)
+
+	// Create stores a new account with its first identity, setting its ID
+	// and CreatedAt.
+	Create(ctx context.Context, account *Account) error
+
+	// LinkIdentity adds an identity to an account, or returns
+	// ErrIdentityInUse when it is linked to another one.
+	LinkIdentity(ctx context.Context, accountID string, identity Identity) error
+
+	// Unlink removes an identity from an account, or returns ErrLastIdentity
+	// when it is the only one.
+	Unlink(ctx context.Context, accountID string, identity Identity) error
+}
+
+// MemoryUserStore is a UserStore keeping accounts in memory, for tests and
+// single instance applications.
+type MemoryUserStore struct {
+	mu         sync.RWMutex
+	accounts   map[string]*Account
+	identities map[Identity]string
+}
+
+// NewMemoryUserStore returns an empty MemoryUserStore.
+func NewMemoryUserStore() *MemoryUserStore {
+	return &MemoryUserStore{accounts: map[string]*Account{}, identities: map[Identity]string{}}
+}
+
+// FindByIdentity implements UserStore.
+func (s *MemoryUserStore) FindByIdentity(ctx context.Context, identity Identity) (*Account, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	id, ok := s.identities[identity]
+	if !ok {
+		return nil, ErrAccountNotFound
+	}
+	return s.copyOf(id), nil
+}
+
+// FindByEmail implements UserStore.
+func (s *MemoryUserStore) FindByEmail(ctx context.Context, email string) (*Account, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	found := ""
+	for id, account := range s.accounts {
+		if email == "" || !strings.EqualFold(account.Email, email) {
+			continue
+		}
+		if account.EmailVerified {
+			return s.copyOf(id), nil
+		}
+		found = id
+	}
+
+	if found == "" {
+		return nil, ErrAccountNotFound
+	}
+	return s.copyOf(found), nil
+}
+
+// FindByID implements AccountUpdater.
+func (s *MemoryUserStore) FindByID(ctx context.Context, id string) (*Account, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, ok := s.accounts[id]; !ok {
+		return nil, ErrAccountNotFound
+	}
+	return s.copyOf(id), nil
+}
+
+// Create implements UserStore.
+func (s *MemoryUserStore) Create(ctx context.Context, account *Account) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, identity := range account.Identities {
+		if _, ok := s.identities[identity]; ok {
+			return ErrIdentityInUse
+		}
+	}
+
+	id, err := randomToken()
+	if err != nil {
+		return err
+	}
+	account.ID = id
+	account.CreatedAt = time.Now()
+
+	stored := *account
+	stored.Identities = append([]Identity{}, account.Identities...)
+	stored.Attributes = copyAttributes(account.Attributes)
+	s.accounts[id] = &stored
+	for _, identity := range account.Identities {
+		s.identities[identity] = id
+	}
+
+	return nil
+}
+
+// LinkIdentity implements UserStore.
+func (s *MemoryUserStore) LinkIdentity(ctx context.Context, accountID string, identity Identity) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	account, ok := s.accounts[accountID]
+	if !ok {
+		return ErrAccountNotFound
+	}
+
+	if owner, ok := s.identities[identity]; ok {
+		if owner != accountID {
+			return ErrIdentityInUse
+		}
+		return nil
+	}
+
+	account.Identities = append(account.Identities, identity)
+	s.identities[identity] = accountID
+	return nil
+}
+
+// SetAttribute implements AccountUpdater.
+func (s *MemoryUserStore) SetAttribute(ctx context.Context, accountID, key, value string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	account, ok := s.accounts[accountID]
+	if !ok {
+		return ErrAccountNotFound
+	}
+
+	if account.Attributes == nil {
+		account.Attributes = map[string]string{}
+	}
+	account.Attributes[key] = value
+	return nil
+}
+
+// Unlink implements UserStore.
+func (s *MemoryUserStore) Unlink(ctx context.Context, accountID string, identity Identity) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	account, ok := s.accounts[accountID]
+	if !ok || s.identities[identity] != accountID {
+		return ErrAccountNotFound
+	}
+
+	if len(account.Identities) == 1 {
+		return ErrLastIdentity
+	}
+
+	identities := account.Identities[:0]
+	for _, i := range account.Identities {
+		if i != identity {
+			identities = append(identities, i)
+		}
+	}
+	account.Identities = identities
+	delete(s.identities, identity)
+	return nil
+}
+
+// copyOf returns a copy of an account, so callers cannot change the stored one.
+func (s *MemoryUserStore) copyOf(id string) *Account {
+	account := *s.accounts[id]
+	account.Identities = append([]Identity{}, account.Identities...)
+	account.Attributes = copyAttributes(account.Attributes)
+	return &account
+}
+
+func copyAttributes(attributes map[string]string) map[string]string {
+	if attributes == nil {
+		return nil
+	}
+
+	c := make(map[string]string, len(attributes))
+	for k, v := range attributes {
+		c[k] = v
+	}
+	return c
+}
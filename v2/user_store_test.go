@@ -0,0 +1,68 @@
+package goth_fiber
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func Test_MemoryUserStore(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store := NewMemoryUserStore()
+
+	google := Identity{Provider: "google", UserID: "1"}
+	github := Identity{Provider: "github", UserID: "2"}
+
+	account := &Account{Email: "Jane@example.com", Identities: []Identity{google}}
+	if err := store.Create(ctx, account); err != nil {
+		t.Fatal(err)
+	}
+	if account.ID == "" || account.CreatedAt.IsZero() {
+		t.Fatalf("expected Create to set the ID and creation time, got %+v", account)
+	}
+
+	if found, err := store.FindByEmail(ctx, "jane@EXAMPLE.com"); err != nil || found.ID != account.ID {
+		t.Errorf("expected to find the account by email, got %+v %v", found, err)
+	}
+
+	verified := &Account{Email: "jane@example.com", EmailVerified: true, Identities: []Identity{{Provider: "okta", UserID: "4"}}}
+	if err := store.Create(ctx, verified); err != nil {
+		t.Fatal(err)
+	}
+	if found, err := store.FindByEmail(ctx, "jane@example.com"); err != nil || found.ID != verified.ID {
+		t.Errorf("expected the account with the verified email to be preferred, got %+v %v", found, err)
+	}
+	if _, err := store.FindByIdentity(ctx, github); !errors.Is(err, ErrAccountNotFound) {
+		t.Errorf("expected ErrAccountNotFound, got %v", err)
+	}
+
+	if err := store.LinkIdentity(ctx, account.ID, github); err != nil {
+		t.Fatal(err)
+	}
+	if found, err := store.FindByIdentity(ctx, github); err != nil || len(found.Identities) != 2 {
+		t.Errorf("expected the linked identity to find the account, got %+v %v", found, err)
+	}
+
+	other := &Account{Identities: []Identity{{Provider: "gitlab", UserID: "3"}}}
+	if err := store.Create(ctx, other); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.LinkIdentity(ctx, other.ID, github); !errors.Is(err, ErrIdentityInUse) {
+		t.Errorf("expected ErrIdentityInUse, got %v", err)
+	}
+	if err := store.Create(ctx, &Account{Identities: []Identity{google}}); !errors.Is(err, ErrIdentityInUse) {
+		t.Errorf("expected ErrIdentityInUse for a new account, got %v", err)
+	}
+
+	if err := store.Unlink(ctx, account.ID, google); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.FindByIdentity(ctx, google); !errors.Is(err, ErrAccountNotFound) {
+		t.Errorf("expected the unlinked identity to be free, got %v", err)
+	}
+	if err := store.Unlink(ctx, account.ID, github); !errors.Is(err, ErrLastIdentity) {
+		t.Errorf("expected ErrLastIdentity, got %v", err)
+	}
+}
@@ -0,0 +1,87 @@
+package goth_fiber
+
+import (
+	"io"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/markbates/goth"
+	"github.com/markbates/goth/providers/faux"
+)
+
+func Test_CompleteUserAuth_StoresUser(t *testing.T) {
+	goth.ClearProviders()
+	goth.UseProviders(&faux.Provider{})
+
+	app := fiber.New()
+	app.Get("/auth/:provider", BeginAuthHandler)
+	app.Get("/callback/:provider", func(c fiber.Ctx) error {
+		if _, err := CompleteUserAuth(c, CompleteUserAuthOptions{ShouldLogout: false}); err != nil {
+			return c.Status(400).SendString(err.Error())
+		}
+		return c.SendString("ok")
+	})
+	app.Get("/me", func(c fiber.Ctx) error {
+		user, err := GetUserFromSession(c)
+		if err != nil {
+			return c.Status(401).SendString(err.Error())
+		}
+		return c.SendString(user.Provider + ":" + user.AccessToken)
+	})
+
+	req := httptest.NewRequest("GET", "/auth/faux?state=test-state", nil)
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookies := resp.Cookies()
+
+	req = httptest.NewRequest("GET", "/callback/faux?code=test-code&state=test-state", nil)
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+	resp, err = app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 200 {
+		body, _ := io.ReadAll(resp.Body)
+		t.Fatalf("expected status 200 on callback, got %d: %s", resp.StatusCode, string(body))
+	}
+
+	req = httptest.NewRequest("GET", "/me", nil)
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+	resp, err = app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != "faux:access" {
+		t.Errorf("expected stored user 'faux:access', got '%s'", string(body))
+	}
+}
+
+func Test_GetUserFromSession_NotFound(t *testing.T) {
+	t.Parallel()
+
+	app := fiber.New()
+	app.Get("/me", func(c fiber.Ctx) error {
+		if _, err := GetUserFromSession(c); err != nil {
+			return c.Status(401).SendString(err.Error())
+		}
+		return c.SendString("found")
+	})
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/me", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.StatusCode != 401 {
+		t.Errorf("expected status 401, got %d", resp.StatusCode)
+	}
+}